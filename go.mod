@@ -10,4 +10,7 @@ require (
 	github.com/hashicorp/vault/sdk v0.1.14-0.20200121232954-73f411823aa0
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/mitchellh/mapstructure v1.1.2
+	github.com/ryanuber/go-glob v1.0.0
+	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e
+	gopkg.in/square/go-jose.v2 v2.3.1
 )
@@ -1,15 +1,42 @@
 package plugin
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/aggregates"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	secgroups "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/mitchellh/mapstructure"
+	"github.com/ryanuber/go-glob"
 )
 
+// maxUserDataSize is the maximum size of the decompressed user data.
+// Nova limits the size of the user data, but not the decompressed size.
+const maxUserDataSize = 16 << 20
+
 type address struct {
 	Version int    `mapstructure:"version"`
 	Address string `mapstructure:"addr"`
@@ -17,46 +44,134 @@ type address struct {
 
 type Attestor struct {
 	storage logical.Storage
+	client  *Client
 }
 
 // NewAttestor returns new attestor.
-func NewAttestor(s logical.Storage) *Attestor {
-	return &Attestor{storage: s}
+func NewAttestor(s logical.Storage, c *Client) *Attestor {
+	return &Attestor{storage: s, client: c}
 }
 
 // Attest is used to attest a OpenStack instance based on binded role and IP address.
-func (at *Attestor) Attest(instance *servers.Server, role *Role, addr string) error {
-	deadline, err := at.VerifyAuthPeriod(instance, role.AuthPeriod)
+// The IP address is not validated if verifyAddr is false.
+func (at *Attestor) Attest(instance *Instance, role *Role, addr string, verifyAddr bool) error {
+	start, err := at.GetAuthPeriodStart(instance, role.AuthPeriodStart)
+	if err != nil {
+		return err
+	}
+
+	deadline, err := at.VerifyAuthPeriod(start, role.AuthPeriod)
+	if err != nil {
+		return err
+	}
+
+	err = at.VerifyMinInstanceAge(start, role.MinInstanceAge)
+	if err != nil {
+		return err
+	}
+
+	_, err = at.VerifyMaxInstanceAge(instance, role.MaxInstanceAge)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestInstanceActions(instance, role.DeniedInstanceActions, role.InstanceActionCutoff)
+	if err != nil {
+		return err
+	}
+
+	_, err = at.VerifyAuthLimit(instance, role.AuthLimit, start, deadline)
+	if err != nil {
+		return err
+	}
+
+	if verifyAddr {
+		err = at.AttestRoleAddr(instance, role, addr)
+		if err != nil {
+			return err
+		}
+
+		err = at.AttestNetwork(instance, addr, role.BoundNetworkIDs, role.BoundSubnetCIDRs)
+		if err != nil {
+			return err
+		}
+	} else if role.usesNetworkBindings() {
+		// The address is not the address of the instance if the validation
+		// is skipped, e.g. the address of the proxy.
+		return errors.New("network bindings require the source address validation")
+	}
+
+	err = at.AttestStatus(instance, role.AllowedStatuses)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestRoleName(instance, role)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestBoundMetadata(instance, role.BoundMetadata)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestInstanceName(instance, role.BoundInstanceNamePatterns, role.InstanceNamePatternType)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestSecurityGroup(instance, role.BoundSecurityGroups, role.VerifyPortSecurityGroups, role.BoundProjectIDs)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestProjectID(instance, role.BoundProjectIDs)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestUserID(instance, role.BoundUserIDs)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestProject(instance, role.BoundParentProjectIDs, role.BoundProjectTags, role.BoundDomainIDs)
+	if err != nil {
+		return err
+	}
+
+	err = at.AttestImage(instance, role.BoundImageIDs, role.BoundImageProperties)
 	if err != nil {
 		return err
 	}
 
-	_, err = at.VerifyAuthLimit(instance, role.AuthLimit, deadline)
+	err = at.AttestFlavor(instance, role.BoundFlavorIDs, role.BoundFlavorNames)
 	if err != nil {
 		return err
 	}
 
-	err = at.AttestAddr(instance, addr)
+	err = at.AttestAvailabilityZone(instance, role.BoundAvailabilityZones)
 	if err != nil {
 		return err
 	}
 
-	err = at.AttestStatus(instance)
+	err = at.AttestHostAggregate(instance, role.BoundHostAggregates)
 	if err != nil {
 		return err
 	}
 
-	err = at.AttestMetadata(instance, role.MetadataKey, role.Name)
+	err = at.AttestKeyPair(instance, role.BoundKeyNames, role.BoundKeyFingerprints)
 	if err != nil {
 		return err
 	}
 
-	err = at.AttestTenantID(instance, role.TenantID)
+	err = at.AttestServerGroup(instance, role.BoundServerGroupIDs)
 	if err != nil {
 		return err
 	}
 
-	err = at.AttestUserID(instance, role.UserID)
+	err = at.AttestServerTags(instance, role.BoundServerTags, role.BoundServerTagsMode)
 	if err != nil {
 		return err
 	}
@@ -64,36 +179,190 @@ func (at *Attestor) Attest(instance *servers.Server, role *Role, addr string) er
 	return nil
 }
 
+// AttestRoleName is used to attest the role name of OpenStack instance.
+// The role name is taken from the server tag if the role tag prefix is
+// specified by a binded role. Otherwise, it is taken from the metadata.
+func (at *Attestor) AttestRoleName(instance *Instance, role *Role) error {
+	if role.RoleTagPrefix != "" {
+		return at.AttestRoleTag(instance, role.RoleTagPrefix, role.Name)
+	}
+
+	return at.AttestMetadata(instance, role.MetadataKey, role.MetadataFormat, role.Name)
+}
+
+// AttestRoleTag is used to attest the role name with the server tags
+// of OpenStack instance.
+func (at *Attestor) AttestRoleTag(instance *Instance, prefix string, roleName string) error {
+	found := false
+
+	for _, tag := range instance.Tags {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+
+		if strings.TrimPrefix(tag, prefix) == roleName {
+			return nil
+		}
+		found = true
+	}
+
+	if !found {
+		return errors.New("role tag not found")
+	}
+
+	return errors.New("role tag mismatched")
+}
+
+// AttestServerTags is used to attest the server tags of OpenStack instance.
+// If mode is 'any', the instance must have any of the tags. Otherwise,
+// the instance must have all of the tags.
+func (at *Attestor) AttestServerTags(instance *Instance, tags []string, mode string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	for _, tag := range tags {
+		ok := strutil.StrListContains(instance.Tags, tag)
+		if mode == TagsModeAny && ok {
+			return nil
+		}
+
+		if mode != TagsModeAny && !ok {
+			return fmt.Errorf("server tag '%s' not found", tag)
+		}
+	}
+
+	if mode == TagsModeAny {
+		return errors.New("server tags mismatched")
+	}
+
+	return nil
+}
+
 // AttestMetadata is used to attest a OpenStack instance metadata.
-func (at *Attestor) AttestMetadata(instance *servers.Server, metadataKey string, roleName string) error {
+// The metadata value can contain multiple role names according to
+// the format specified by a binded role.
+func (at *Attestor) AttestMetadata(instance *Instance, metadataKey string, format string, roleName string) error {
 	val, ok := instance.Metadata[metadataKey]
 	if !ok {
 		return errors.New("metadata key not found")
 	}
 
-	if val != roleName {
+	roleNames, err := parseMetadataRoles(val, format)
+	if err != nil {
+		return err
+	}
+
+	if !strutil.StrListContains(roleNames, roleName) {
 		return errors.New("metadata role name mismatched")
 	}
 
 	return nil
 }
 
+// AttestBoundMetadata is used to attest the metadata of OpenStack instance
+// with the bindings. Each key of the bindings must be present in the
+// metadata and its value must match one of the allowed values.
+func (at *Attestor) AttestBoundMetadata(instance *Instance, bound map[string][]string) error {
+	for key, patterns := range bound {
+		val, ok := instance.Metadata[key]
+		if !ok {
+			return fmt.Errorf("metadata key '%s' not found", key)
+		}
+
+		matched := false
+		for _, pattern := range patterns {
+			if glob.Glob(pattern, val) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("metadata '%s' mismatched", key)
+		}
+	}
+
+	return nil
+}
+
+// AttestInstanceName is used to attest the name and the hostname of
+// OpenStack instance with the patterns. The hostname is validated only if
+// it is available.
+func (at *Attestor) AttestInstanceName(instance *Instance, patterns []string, patternType string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	ok, err := matchPatterns(patterns, patternType, instance.Name)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("instance name '%s' mismatched", instance.Name)
+	}
+
+	if instance.Hostname != "" {
+		ok, err = matchPatterns(patterns, patternType, instance.Hostname)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("instance hostname '%s' mismatched", instance.Hostname)
+		}
+	}
+
+	return nil
+}
+
 // AttestStatus is used to attest the status of OpenStack instance.
-func (at *Attestor) AttestStatus(instance *servers.Server) error {
-	if instance.Status != "ACTIVE" {
-		return errors.New("instance is not active")
+// Locked, rescued and shelved instances and the instances in the middle of
+// a task are rejected regardless of the allowed statuses. The disk of the
+// rescued instance may be controlled by the user of the rescue image.
+func (at *Attestor) AttestStatus(instance *Instance, statuses []string) error {
+	if len(statuses) == 0 {
+		statuses = defaultAllowedStatuses
+	}
+
+	if !strutil.StrListContains(statuses, instance.Status) {
+		return fmt.Errorf("instance status '%s' is not allowed", instance.Status)
+	}
+
+	if instance.Locked {
+		return errors.New("instance is locked")
+	}
+
+	switch instance.VmState {
+	case "rescued":
+		return errors.New("instance is rescued")
+	case "shelved", "shelved_offloaded":
+		return errors.New("instance is shelved")
+	}
+
+	if instance.TaskState != "" {
+		return fmt.Errorf("instance is in the middle of task '%s'", instance.TaskState)
 	}
 
 	return nil
 }
 
 // AttestAddr is used to attest the IP address of OpenStack instance
-// with source IP address. This method support IPv4 only.
-func (at *Attestor) AttestAddr(instance *servers.Server, addr string) error {
+// with source IP address. Both IPv4 and IPv6 addresses are supported and
+// compared in their normalized form.
+func (at *Attestor) AttestAddr(instance *Instance, addr string) error {
 	var addresses map[string][]address
 
-	if instance.AccessIPv4 == addr {
-		return nil
+	src := parseAddr(addr)
+	if src == nil {
+		return fmt.Errorf("invalid source address: %s", addr)
+	}
+
+	for _, access := range []string{instance.AccessIPv4, instance.AccessIPv6} {
+		if src.Equal(parseAddr(access)) {
+			return nil
+		}
 	}
 
 	err := mapstructure.Decode(instance.Addresses, &addresses)
@@ -103,11 +372,11 @@ func (at *Attestor) AttestAddr(instance *servers.Server, addr string) error {
 
 	for _, addrs := range addresses {
 		for _, val := range addrs {
-			if val.Version != 4 {
+			if val.Version != 4 && val.Version != 6 {
 				continue
 			}
 
-			if val.Address == addr {
+			if src.Equal(parseAddr(val.Address)) {
 				return nil
 			}
 		}
@@ -116,72 +385,986 @@ func (at *Attestor) AttestAddr(instance *servers.Server, addr string) error {
 	return errors.New("address mismatched")
 }
 
-// AttestTenantID is used to attest the tenant ID of OpenStack instance.
-func (at *Attestor) AttestTenantID(instance *servers.Server, tenantID string) error {
-	if tenantID == "" {
+// AttestRoleAddr is used to attest the IP address of OpenStack instance
+// with source IP address based on the address source of a binded role.
+func (at *Attestor) AttestRoleAddr(instance *Instance, role *Role, addr string) error {
+	switch role.AddressSource {
+	case AddressSourceNeutron:
+		return at.AttestPortAddr(instance, addr)
+	default:
+		return at.AttestAddr(instance, addr)
+	}
+}
+
+// AttestPortAddr is used to attest the IP address of OpenStack instance
+// with source IP address based on the Neutron ports of the instance.
+// The fixed IPs, allowed address pairs and associated floating IPs of
+// all ports attached to the instance are accepted.
+func (at *Attestor) AttestPortAddr(instance *Instance, addr string) error {
+	src := parseAddr(addr)
+	if src == nil {
+		return fmt.Errorf("invalid source address: %s", addr)
+	}
+
+	port, _, err := at.findPort(instance, src)
+	if err != nil {
+		return err
+	}
+
+	if port == nil {
+		return errors.New("address mismatched")
+	}
+
+	return nil
+}
+
+// AttestNetwork is used to attest the network and the subnet that the
+// source IP address belongs to. The Neutron port that has the source IP
+// address must be attached to one of the networks and its fixed IP must
+// be contained in one of the CIDR blocks.
+func (at *Attestor) AttestNetwork(instance *Instance, addr string, networkIDs []string, cidrs []string) error {
+	if len(networkIDs) == 0 && len(cidrs) == 0 {
+		return nil
+	}
+
+	src := parseAddr(addr)
+	if src == nil {
+		return fmt.Errorf("invalid source address: %s", addr)
+	}
+
+	port, fixedIP, err := at.findPort(instance, src)
+	if err != nil {
+		return err
+	}
+
+	if port == nil {
+		return errors.New("port of the source address not found")
+	}
+
+	if len(networkIDs) > 0 && !strutil.StrListContains(networkIDs, port.NetworkID) {
+		return fmt.Errorf("network '%s' mismatched", port.NetworkID)
+	}
+
+	if len(cidrs) > 0 {
+		matched := false
+		for _, cidr := range cidrs {
+			if containsAddr(cidr, fixedIP) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("subnet of '%s' mismatched", fixedIP)
+		}
+	}
+
+	return nil
+}
+
+// AttestSecurityGroup is used to attest the security groups of OpenStack
+// instance. Since any project can create a security group with the same
+// name, the security groups are matched by the name only if the role is
+// bound to the projects. Nova reports only the names of the security
+// groups, so the security groups can be specified by the ID only if
+// verifyPorts is true. In that case, the security groups are verified with
+// the Neutron ports of the instance instead of the security groups reported
+// by Nova, and the security group matched by the name must be owned by one
+// of the projects.
+func (at *Attestor) AttestSecurityGroup(instance *Instance, groups []string, verifyPorts bool, projectIDs []string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	if verifyPorts {
+		return at.attestPortSecurityGroup(instance, groups, projectIDs)
+	}
+
+	// The project of the instance is verified by AttestProjectID, and Nova
+	// reports only the security groups available to the project.
+	if len(projectIDs) == 0 {
+		return errors.New("security group names require bound project IDs")
+	}
+
+	for _, sg := range instance.SecurityGroups {
+		name, _ := sg["name"].(string)
+		if name != "" && strutil.StrListContains(groups, name) {
+			return nil
+		}
+	}
+
+	return errors.New("security group mismatched")
+}
+
+// attestPortSecurityGroup is used to attest the security groups of the
+// Neutron ports attached to OpenStack instance.
+func (at *Attestor) attestPortSecurityGroup(instance *Instance, groups []string, projectIDs []string) error {
+	portList, err := at.listPorts(instance)
+	if err != nil {
+		return err
+	}
+
+	for _, port := range portList {
+		for _, sgID := range port.SecurityGroups {
+			if strutil.StrListContains(groups, sgID) {
+				return nil
+			}
+
+			if len(projectIDs) == 0 {
+				continue
+			}
+
+			sg, err := secgroups.Get(at.client.Network, sgID).Extract()
+			if err != nil {
+				return err
+			}
+
+			owner := sg.ProjectID
+			if owner == "" {
+				owner = sg.TenantID
+			}
+
+			if strutil.StrListContains(groups, sg.Name) && strutil.StrListContains(projectIDs, owner) {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("port security group mismatched")
+}
+
+// AttestProjectID is used to attest the project ID of OpenStack instance.
+func (at *Attestor) AttestProjectID(instance *Instance, projectIDs []string) error {
+	if len(projectIDs) == 0 {
 		return nil
 	}
 
-	if instance.TenantID != tenantID {
-		return errors.New("tenant ID mismatched")
+	if !strutil.StrListContains(projectIDs, instance.TenantID) {
+		return errors.New("project ID mismatched")
 	}
 
 	return nil
 }
 
 // AttestUserID is used to attest the user ID of OpenStack instance.
-func (at *Attestor) AttestUserID(instance *servers.Server, userID string) error {
-	if userID == "" {
+func (at *Attestor) AttestUserID(instance *Instance, userIDs []string) error {
+	if len(userIDs) == 0 {
 		return nil
 	}
 
-	if instance.UserID != userID {
+	if !strutil.StrListContains(userIDs, instance.UserID) {
 		return errors.New("user ID mismatched")
 	}
 
 	return nil
 }
 
-// VerifyAuthPeriod is used to verify the deadline of authentication.
-// The deadline is calculated by the create date of OpenStack instance and
-// the authentication period specified by a binded role.
-func (at *Attestor) VerifyAuthPeriod(instance *servers.Server, period time.Duration) (time.Time, error) {
-	deadline := instance.Created.Add(period)
-	if time.Now().After(deadline) {
-		return deadline, errors.New("authentication deadline exceeded")
+// AttestProject is used to attest the Keystone project of OpenStack
+// instance. The project must be a descendant of one of the parent projects,
+// have one of the project tags and belong to one of the domains.
+func (at *Attestor) AttestProject(instance *Instance, parentIDs []string, tags []string, domainIDs []string) error {
+	if len(parentIDs) == 0 && len(tags) == 0 && len(domainIDs) == 0 {
+		return nil
 	}
 
-	return deadline, nil
+	if at.client == nil || at.client.Projects == nil {
+		return errors.New("identity client is not available")
+	}
+
+	project, err := at.client.Projects.Get(instance.TenantID)
+	if err != nil {
+		return err
+	}
+
+	if len(domainIDs) > 0 && !strutil.StrListContains(domainIDs, project.DomainID) {
+		return fmt.Errorf("domain ID '%s' mismatched", project.DomainID)
+	}
+
+	if len(tags) > 0 {
+		matched := false
+		for _, tag := range tags {
+			if strutil.StrListContains(project.Tags, tag) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return errors.New("project tags mismatched")
+		}
+	}
+
+	if len(parentIDs) > 0 {
+		parents, err := at.client.Projects.GetParents(project)
+		if err != nil {
+			return err
+		}
+
+		matched := false
+		for _, parentID := range parents {
+			if strutil.StrListContains(parentIDs, parentID) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return errors.New("parent project mismatched")
+		}
+	}
+
+	return nil
 }
 
-// VerifyAuthLimit is used to verify the number of attempts of authentication.
-// The limit of authentication is specified by a binded role.
-func (at *Attestor) VerifyAuthLimit(instance *servers.Server, limit int, deadline time.Time) (int, error) {
-	ctx := context.Background()
+// AttestImage is used to attest the image of OpenStack instance. The image
+// properties are fetched from the image service only if the properties are
+// specified. The image of the instance booted from volume is resolved by
+// the image metadata of the root volume.
+func (at *Attestor) AttestImage(instance *Instance, imageIDs []string, properties map[string]string) error {
+	if len(imageIDs) == 0 && len(properties) == 0 {
+		return nil
+	}
 
-	attempt, err := readAuthAttempt(ctx, at.storage, instance.ID)
+	imageID, imageProps, err := at.getImage(instance, len(properties) > 0)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	if attempt == nil {
-		attempt = &AuthAttempt{
-			Name:     instance.ID,
-			Deadline: deadline,
-			Count:    0,
+	if len(imageIDs) > 0 && !strutil.StrListContains(imageIDs, imageID) {
+		return errors.New("image ID mismatched")
+	}
+
+	for key, pattern := range properties {
+		val, ok := imageProps[key]
+		if !ok {
+			return fmt.Errorf("image property '%s' not found", key)
+		}
+
+		if !glob.Glob(pattern, val) {
+			return fmt.Errorf("image property '%s' mismatched", key)
 		}
 	}
 
-	attempt.Count = attempt.Count + 1
+	return nil
+}
 
-	err = updateAuthAttempt(ctx, at.storage, attempt)
-	if err != nil {
-		return attempt.Count, err
+// getImage returns the image ID and the image properties of OpenStack
+// instance.
+func (at *Attestor) getImage(instance *Instance, withProps bool) (string, map[string]string, error) {
+	imageID, _ := instance.Image["id"].(string)
+	if imageID == "" {
+		return at.getVolumeImage(instance)
 	}
 
-	if attempt.Count > limit {
-		return attempt.Count, errors.New("too many authentication failures")
+	if !withProps {
+		return imageID, nil, nil
 	}
 
-	return attempt.Count, nil
+	if at.client == nil || at.client.Image == nil {
+		return "", nil, errors.New("image client is not available")
+	}
+
+	image, err := images.Get(at.client.Image, imageID).Extract()
+	if err != nil {
+		return "", nil, err
+	}
+
+	props := map[string]string{}
+	for key, val := range image.Properties {
+		props[key] = fmt.Sprint(val)
+	}
+
+	return imageID, props, nil
+}
+
+// getVolumeImage returns the image ID and the image properties of
+// OpenStack instance booted from volume. The root volume is the volume
+// attached to the root device of the instance. If the root device name is
+// not available, the bootable volume attached to the first device of the
+// instance is used instead.
+func (at *Attestor) getVolumeImage(instance *Instance) (string, map[string]string, error) {
+	if at.client == nil || at.client.BlockStorage == nil {
+		return "", nil, errors.New("block storage client is not available")
+	}
+
+	var root *volumes.Volume
+	var rootDevice string
+
+	for _, attached := range instance.AttachedVolumes {
+		volume, err := volumes.Get(at.client.BlockStorage, attached.ID).Extract()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if len(volume.VolumeImageMetadata) == 0 {
+			continue
+		}
+
+		if instance.RootDeviceName == "" && volume.Bootable != "true" {
+			continue
+		}
+
+		for _, attachment := range volume.Attachments {
+			if attachment.ServerID != instance.ID {
+				continue
+			}
+
+			if instance.RootDeviceName != "" {
+				if attachment.Device == instance.RootDeviceName {
+					root = volume
+				}
+				continue
+			}
+
+			if root == nil || attachment.Device < rootDevice {
+				root = volume
+				rootDevice = attachment.Device
+			}
+		}
+	}
+
+	if root == nil {
+		return "", nil, errors.New("root volume not found")
+	}
+
+	return root.VolumeImageMetadata["image_id"], root.VolumeImageMetadata, nil
+}
+
+// AttestFlavor is used to attest the flavor of OpenStack instance.
+// The flavor name is fetched from the compute service if the flavor
+// of the instance does not contain the original name.
+func (at *Attestor) AttestFlavor(instance *Instance, flavorIDs []string, flavorNames []string) error {
+	flavorID, _ := instance.Flavor["id"].(string)
+
+	if len(flavorIDs) > 0 {
+		if flavorID == "" {
+			return errors.New("flavor ID not found")
+		}
+
+		if !strutil.StrListContains(flavorIDs, flavorID) {
+			return fmt.Errorf("flavor ID '%s' mismatched", flavorID)
+		}
+	}
+
+	if len(flavorNames) > 0 {
+		flavorName, _ := instance.Flavor["original_name"].(string)
+		if flavorName == "" && flavorID != "" {
+			if at.client == nil || at.client.Compute == nil {
+				return errors.New("compute client is not available")
+			}
+
+			flavor, err := flavors.Get(at.client.Compute, flavorID).Extract()
+			if err != nil {
+				return err
+			}
+			flavorName = flavor.Name
+		}
+
+		if flavorName == "" {
+			return errors.New("flavor name not found")
+		}
+
+		if !strutil.StrListContains(flavorNames, flavorName) {
+			return fmt.Errorf("flavor name '%s' mismatched", flavorName)
+		}
+	}
+
+	return nil
+}
+
+// AttestAvailabilityZone is used to attest the availability zone of
+// OpenStack instance.
+func (at *Attestor) AttestAvailabilityZone(instance *Instance, zones []string) error {
+	if len(zones) == 0 {
+		return nil
+	}
+
+	if !strutil.StrListContains(zones, instance.AvailabilityZone) {
+		return fmt.Errorf("availability zone '%s' mismatched", instance.AvailabilityZone)
+	}
+
+	return nil
+}
+
+// AttestHostAggregate is used to attest the host aggregates that the host
+// of OpenStack instance belongs to. The host aggregates are specified by
+// the ID or the name.
+func (at *Attestor) AttestHostAggregate(instance *Instance, aggrs []string) error {
+	if len(aggrs) == 0 {
+		return nil
+	}
+
+	if instance.Host == "" {
+		return errors.New("instance host not found")
+	}
+
+	if at.client == nil || at.client.Compute == nil {
+		return errors.New("compute client is not available")
+	}
+
+	page, err := aggregates.List(at.client.Compute).AllPages()
+	if err != nil {
+		return err
+	}
+
+	aggrList, err := aggregates.ExtractAggregates(page)
+	if err != nil {
+		return err
+	}
+
+	for _, aggr := range aggrList {
+		if !strutil.StrListContains(aggrs, strconv.Itoa(aggr.ID)) && !strutil.StrListContains(aggrs, aggr.Name) {
+			continue
+		}
+
+		if strutil.StrListContains(aggr.Hosts, instance.Host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host '%s' is not a member of the host aggregates", instance.Host)
+}
+
+// AttestKeyPair is used to attest the keypair of OpenStack instance with
+// the keypair names and fingerprints. The fingerprint is resolved with the
+// keypairs API using the user ID of the instance owner.
+func (at *Attestor) AttestKeyPair(instance *Instance, names []string, fingerprints []string) error {
+	if len(names) == 0 && len(fingerprints) == 0 {
+		return nil
+	}
+
+	if instance.KeyName == "" {
+		return errors.New("keypair not found")
+	}
+
+	if len(names) > 0 && !strutil.StrListContains(names, instance.KeyName) {
+		return fmt.Errorf("keypair name '%s' mismatched", instance.KeyName)
+	}
+
+	if len(fingerprints) > 0 {
+		if at.client == nil || at.client.Compute == nil {
+			return errors.New("compute client is not available")
+		}
+
+		keypair, err := getInstanceKeyPair(at.client.Compute, instance)
+		if err != nil {
+			return err
+		}
+
+		if !strutil.StrListContains(fingerprints, keypair.Fingerprint) {
+			return fmt.Errorf("keypair fingerprint '%s' mismatched", keypair.Fingerprint)
+		}
+	}
+
+	return nil
+}
+
+// AttestServerGroup is used to attest that OpenStack instance is a member
+// of one of the server groups.
+func (at *Attestor) AttestServerGroup(instance *Instance, groupIDs []string) error {
+	if len(groupIDs) == 0 {
+		return nil
+	}
+
+	if at.client == nil || at.client.Compute == nil {
+		return errors.New("compute client is not available")
+	}
+
+	for _, groupID := range groupIDs {
+		group, err := servergroups.Get(at.client.Compute, groupID).Extract()
+		if err != nil {
+			if _, ok := err.(gophercloud.ErrDefault404); ok {
+				continue
+			}
+			return err
+		}
+
+		if strutil.StrListContains(group.Members, instance.ID) {
+			return nil
+		}
+	}
+
+	return errors.New("instance is not a member of the server groups")
+}
+
+// GetAuthPeriodStart returns the start time of the authentication period.
+// The start time is the creation time, the launch time or the time of the
+// latest rebuild of OpenStack instance.
+func (at *Attestor) GetAuthPeriodStart(instance *Instance, start string) (time.Time, error) {
+	switch start {
+	case "", AuthPeriodStartCreated:
+		return instance.Created, nil
+	case AuthPeriodStartLaunched:
+		if instance.LaunchedAt.IsZero() {
+			return time.Time{}, errors.New("launch time not found")
+		}
+		return instance.LaunchedAt, nil
+	case AuthPeriodStartRebuilt:
+		if at.client == nil || at.client.Compute == nil {
+			return time.Time{}, errors.New("compute client is not available")
+		}
+
+		actions, err := listInstanceActions(at.client.Compute, instance.ID)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		rebuilt := instance.Created
+		for _, action := range actions {
+			if action.Action == "rebuild" && action.StartTime.After(rebuilt) {
+				rebuilt = action.StartTime
+			}
+		}
+		return rebuilt, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid auth period start: %s", start)
+}
+
+// VerifyAuthPeriod is used to verify the deadline of authentication.
+// The deadline is calculated by the start time of the authentication period
+// and the authentication period specified by a binded role.
+func (at *Attestor) VerifyAuthPeriod(start time.Time, period time.Duration) (time.Time, error) {
+	deadline := start.Add(period)
+	if time.Now().After(deadline) {
+		return deadline, errors.New("authentication deadline exceeded")
+	}
+
+	return deadline, nil
+}
+
+// VerifyMinInstanceAge is used to verify that the authentication period
+// has elapsed at least the minimum age specified by a binded role.
+func (at *Attestor) VerifyMinInstanceAge(start time.Time, minAge time.Duration) error {
+	if minAge <= 0 {
+		return nil
+	}
+
+	if time.Now().Before(start.Add(minAge)) {
+		return errors.New("instance is too young to authenticate")
+	}
+
+	return nil
+}
+
+// VerifyMaxInstanceAge is used to verify that OpenStack instance is not
+// older than the maximum age specified by a binded role. It returns the
+// remaining age of the instance.
+func (at *Attestor) VerifyMaxInstanceAge(instance *Instance, maxAge time.Duration) (time.Duration, error) {
+	if maxAge <= 0 {
+		return 0, nil
+	}
+
+	remaining := time.Until(instance.Created.Add(maxAge))
+	if remaining <= 0 {
+		return 0, errors.New("instance is too old to authenticate")
+	}
+
+	return remaining, nil
+}
+
+// AttestInstanceActions is used to attest that OpenStack instance has no
+// denied actions after the cutoff in its instance action log. Rebuilding or
+// evacuating the instance does not change its ID, metadata and creation
+// time, so the instance action log is the only way to detect them.
+func (at *Attestor) AttestInstanceActions(instance *Instance, denied []string, cutoff string) error {
+	if cutoff == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if len(denied) == 0 {
+		denied = defaultDeniedInstanceActions
+	}
+
+	if at.client == nil || at.client.Compute == nil {
+		return errors.New("compute client is not available")
+	}
+
+	actions, err := listInstanceActions(at.client.Compute, instance.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if action.StartTime.After(t) && strutil.StrListContains(denied, action.Action) {
+			return fmt.Errorf("instance action '%s' detected at %s", action.Action, action.StartTime.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// AttestUserDataToken is used to attest that the token is embedded in the
+// user data of OpenStack instance. The user data must contain the marker
+// followed by the SHA-256 hash of the token in hex. The gzip compressed
+// user data is also supported.
+func (at *Attestor) AttestUserDataToken(instance *Instance, marker string, token string) error {
+	if marker == "" {
+		return nil
+	}
+
+	if token == "" {
+		return errors.New("user data token required")
+	}
+
+	if instance.UserData == "" {
+		return errors.New("user data not found")
+	}
+
+	userData, err := decodeUserData(instance.UserData)
+	if err != nil {
+		return err
+	}
+
+	i := bytes.Index(userData, []byte(marker))
+	if i < 0 {
+		return errors.New("user data marker not found")
+	}
+
+	hash := userData[i+len(marker):]
+	if len(hash) < sha256.Size*2 {
+		return errors.New("invalid user data token hash")
+	}
+	hash = bytes.ToLower(hash[:sha256.Size*2])
+
+	if subtle.ConstantTimeCompare(hash, []byte(hashSecret(token))) != 1 {
+		return errors.New("user data token mismatched")
+	}
+
+	return nil
+}
+
+// VerifyIdentityNonce is used to verify that the nonce of the identity
+// document has not been used. The nonce is recorded until the identity
+// document expires.
+func (at *Attestor) VerifyIdentityNonce(doc *IdentityDocument, skew time.Duration) error {
+	ctx := context.Background()
+
+	if skew <= 0 {
+		skew = defaultIdentityDocumentClockSkew
+	}
+
+	// The nonce is hashed since it is used as the storage key.
+	name := hashSecret(doc.Nonce)
+
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	nonce, err := readIdentityNonce(ctx, at.storage, name)
+	if err != nil {
+		return err
+	}
+
+	if nonce != nil {
+		return errors.New("identity document has already been used")
+	}
+
+	return updateIdentityNonce(ctx, at.storage, &IdentityNonce{
+		Name:     name,
+		Deadline: doc.Timestamp.Add(skew),
+	})
+}
+
+// VerifySecrets is used to verify the single-use secrets bound to OpenStack
+// instance. The secrets are keyed by their kinds. All of the secrets are
+// verified before any of them is marked as used, so that a failure of one
+// secret does not consume the others.
+func (at *Attestor) VerifySecrets(instance *Instance, secrets map[string]string) error {
+	ctx := context.Background()
+
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	verified := map[string]*Secret{}
+	for _, kind := range secretKinds {
+		secret, ok := secrets[kind]
+		if !ok {
+			continue
+		}
+
+		stored, err := at.verifySecret(ctx, instance, kind, secret)
+		if err != nil {
+			return fmt.Errorf("%s: %v", kind, err)
+		}
+
+		verified[kind] = stored
+	}
+
+	for kind, stored := range verified {
+		stored.Used = true
+
+		err := updateSecret(ctx, at.storage, kind, stored)
+		if err != nil {
+			return err
+		}
+
+		err = deleteSecretCache(ctx, at.storage, kind, stored.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (at *Attestor) verifySecret(ctx context.Context, instance *Instance, kind string, secret string) (*Secret, error) {
+	if secret == "" {
+		return nil, errors.New("secret required")
+	}
+
+	stored, err := readSecret(ctx, at.storage, kind, secretName(kind, instance.ID, secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if stored == nil {
+		return nil, errors.New("secret not found")
+	}
+
+	if stored.Used {
+		return nil, errors.New("secret has already been used")
+	}
+
+	if time.Now().After(stored.Deadline) {
+		return nil, errors.New("secret expired")
+	}
+
+	if !stored.Verify(secret) {
+		return nil, errors.New("secret mismatched")
+	}
+
+	return stored, nil
+}
+
+// VerifyAuthLimit is used to verify the number of attempts of authentication.
+// The limit of authentication is specified by a binded role.
+func (at *Attestor) VerifyAuthLimit(instance *Instance, limit int, start time.Time, deadline time.Time) (int, error) {
+	ctx := context.Background()
+
+	attempt, err := readAuthAttempt(ctx, at.storage, instance.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The attempts are counted per instance regardless of the role, so the
+	// deadline of another role must not reset the count. The count is reset
+	// only when a new authentication period starts after the previous one,
+	// e.g. by rebuilding the instance.
+	if attempt == nil || start.After(attempt.Deadline) {
+		attempt = &AuthAttempt{
+			Name:     instance.ID,
+			Deadline: deadline,
+			Count:    0,
+		}
+	}
+
+	// Keep the attempt until the latest deadline of the roles.
+	if deadline.After(attempt.Deadline) {
+		attempt.Deadline = deadline
+	}
+
+	attempt.Count = attempt.Count + 1
+
+	err = updateAuthAttempt(ctx, at.storage, attempt)
+	if err != nil {
+		return attempt.Count, err
+	}
+
+	if attempt.Count > limit {
+		return attempt.Count, errors.New("too many authentication failures")
+	}
+
+	return attempt.Count, nil
+}
+
+// parseAddr parses the textual form of an IP address. Brackets and zone
+// IDs are removed and IPv4-mapped IPv6 addresses are converted to IPv4
+// so that all representations of the same address compare equal.
+// It returns nil if the address is empty or invalid.
+func parseAddr(addr string) net.IP {
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	i := strings.LastIndex(addr, "%")
+	if i >= 0 {
+		addr = addr[:i]
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	v4 := ip.To4()
+	if v4 != nil {
+		return v4
+	}
+
+	return ip
+}
+
+// listPorts returns the Neutron ports attached to OpenStack instance.
+func (at *Attestor) listPorts(instance *Instance) ([]ports.Port, error) {
+	if at.client == nil || at.client.Network == nil {
+		return nil, errors.New("network client is not available")
+	}
+
+	page, err := ports.List(at.client.Network, ports.ListOpts{DeviceID: instance.ID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return ports.ExtractPorts(page)
+}
+
+// findPort returns the Neutron port of OpenStack instance that has the IP
+// address as the fixed IP, the allowed address pair or the associated
+// floating IP. Only the allowed address pairs of a single address are used,
+// since the pairs of wide CIDR blocks such as 0.0.0.0/0 are common on VRRP
+// ports and would match any address. It also returns the fixed IP of the
+// port that corresponds to the IP address. It returns nil if the port is
+// not found.
+func (at *Attestor) findPort(instance *Instance, ip net.IP) (*ports.Port, net.IP, error) {
+	portList, err := at.listPorts(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, port := range portList {
+		for _, fixedIP := range port.FixedIPs {
+			if ip.Equal(parseAddr(fixedIP.IPAddress)) {
+				return &portList[i], ip, nil
+			}
+		}
+
+		for _, pair := range port.AllowedAddressPairs {
+			if isHostAddr(pair.IPAddress) && containsAddr(pair.IPAddress, ip) {
+				return &portList[i], ip, nil
+			}
+		}
+
+		page, err := floatingips.List(at.client.Network, floatingips.ListOpts{PortID: port.ID}).AllPages()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fips, err := floatingips.ExtractFloatingIPs(page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, fip := range fips {
+			if ip.Equal(parseAddr(fip.FloatingIP)) {
+				return &portList[i], parseAddr(fip.FixedIP), nil
+			}
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// decodeUserData decodes the base64 encoded user data. The user data is
+// decompressed if it is compressed with gzip.
+func decodeUserData(val string) ([]byte, error) {
+	userData, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user data: %v", err)
+	}
+
+	if !bytes.HasPrefix(userData, []byte{0x1f, 0x8b}) {
+		return userData, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(userData))
+	if err != nil {
+		return nil, fmt.Errorf("invalid user data: %v", err)
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(io.LimitReader(r, maxUserDataSize))
+}
+
+// parseMetadataRoles parses the role names in the metadata value. The role
+// names are lowercased since the roles are stored with the lowercased names.
+func parseMetadataRoles(val string, format string) ([]string, error) {
+	var roleNames []string
+
+	switch format {
+	case "", MetadataFormatString:
+		roleNames = []string{val}
+	case MetadataFormatCSV:
+		roleNames = strutil.ParseDedupAndSortStrings(val, ",")
+	case MetadataFormatJSON:
+		err := json.Unmarshal([]byte(val), &roleNames)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata role names: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid metadata format: %s", format)
+	}
+
+	for i, roleName := range roleNames {
+		roleNames[i] = strings.ToLower(roleName)
+	}
+
+	return roleNames, nil
+}
+
+// matchPatterns returns true if the value matches one of the glob patterns
+// or the regular expressions.
+func matchPatterns(patterns []string, patternType string, val string) (bool, error) {
+	for _, pattern := range patterns {
+		if patternType != PatternTypeRegex {
+			if glob.Glob(pattern, val) {
+				return true, nil
+			}
+			continue
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		if re.MatchString(val) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isHostAddr returns true if the given IP address or CIDR block contains
+// only a single IP address.
+func isHostAddr(cidr string) bool {
+	if !strings.Contains(cidr, "/") {
+		return parseAddr(cidr) != nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	return ones == bits
+}
+
+// containsAddr returns true if the given IP address or CIDR block
+// contains the IP address.
+func containsAddr(cidr string, ip net.IP) bool {
+	if !strings.Contains(cidr, "/") {
+		return ip.Equal(parseAddr(cidr))
+	}
+
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+
+	return ipnet.Contains(ip)
 }
@@ -1,26 +1,34 @@
 package plugin
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 )
 
-func newTestInstance() *servers.Server {
-	return &servers.Server{
-		ID:         "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
-		Name:       "test",
-		UserID:     "9349aff8be7545ac9d2f1d00999a23cd",
-		TenantID:   "fcad67a6189847c4aecfa3c81a05783b",
-		HostID:     "29d3c8c896a45aa4c34e52247875d7fefc3d94bbcc9f622b5d204362",
-		Status:     "ACTIVE",
-		AccessIPv4: "",
-		Addresses:  map[string]interface{}{},
-		Metadata:   map[string]string{},
-		Created:    time.Now(),
-		Updated:    time.Now(),
+func newTestInstance() *Instance {
+	return &Instance{
+		Server: servers.Server{
+			ID:         "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			Name:       "test",
+			UserID:     "9349aff8be7545ac9d2f1d00999a23cd",
+			TenantID:   "fcad67a6189847c4aecfa3c81a05783b",
+			HostID:     "29d3c8c896a45aa4c34e52247875d7fefc3d94bbcc9f622b5d204362",
+			Status:     "ACTIVE",
+			AccessIPv4: "",
+			Addresses:  map[string]interface{}{},
+			Metadata:   map[string]string{},
+			Created:    time.Now(),
+			Updated:    time.Now(),
+		},
 	}
 }
 
@@ -45,18 +53,18 @@ func TestAttest(t *testing.T) {
 	}
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
 
 	role := &Role{
-		Name:        "test",
-		Policies:    []string{"test"},
-		TTL:         time.Duration(60) * time.Second,
-		MaxTTL:      time.Duration(120) * time.Second,
-		Period:      time.Duration(120) * time.Second,
-		MetadataKey: "vault-role",
-		TenantID:    "fcad67a6189847c4aecfa3c81a05783b",
-		AuthPeriod:  time.Duration(120) * time.Second,
-		AuthLimit:   2,
+		Name:            "test",
+		Policies:        []string{"test"},
+		TTL:             time.Duration(60) * time.Second,
+		MaxTTL:          time.Duration(120) * time.Second,
+		Period:          time.Duration(120) * time.Second,
+		MetadataKey:     "vault-role",
+		BoundProjectIDs: []string{"fcad67a6189847c4aecfa3c81a05783b"},
+		AuthPeriod:      time.Duration(120) * time.Second,
+		AuthLimit:       2,
 	}
 
 	for i, test := range tests {
@@ -71,56 +79,222 @@ func TestAttest(t *testing.T) {
 		instance.Created = time.Now().Add(time.Duration(test.diff) * time.Second)
 
 		for i := 0; i < test.attempt; i++ {
-			err = attestor.Attest(instance, role, "192.168.1.1")
+			err = attestor.Attest(instance, role, "192.168.1.1", true)
 		}
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
 	}
+
+	// The network bindings cannot be verified with the address of the proxy
+	// if the source address validation is skipped.
+	instance := newTestInstance()
+	instance.Metadata["vault-role"] = "test"
+	instance.TenantID = "fcad67a6189847c4aecfa3c81a05783b"
+
+	err := attestor.Attest(instance, role, "10.0.0.1", false)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	role.BoundNetworkIDs = []string{"trusted"}
+	err = attestor.Attest(instance, role, "10.0.0.1", false)
+	if err == nil {
+		t.Errorf("expected error with network bindings without address validation")
+	}
 }
 
 func TestAttestMetadata(t *testing.T) {
 	var tests = []struct {
 		key    string
 		val    string
+		format string
 		result bool
 	}{
-		{"vault-role", "test", true},
-		{"invalid", "test", false},
-		{"vault-role", "invalid", false},
+		{"vault-role", "test", "", true},
+		{"vault-role", "test", "string", true},
+		{"invalid", "test", "", false},
+		{"vault-role", "invalid", "", false},
+		{"vault-role", "dev,test", "string", false},
+		{"vault-role", "dev, test", "csv", true},
+		{"vault-role", "dev,prod", "csv", false},
+		{"vault-role", `["dev", "test"]`, "json", true},
+		{"vault-role", `["dev", "prod"]`, "json", false},
+		{"vault-role", "dev,test", "json", false},
+		{"vault-role", "Test", "", true},
+		{"vault-role", "dev, TEST", "csv", true},
 	}
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
 
 	for _, test := range tests {
 		instance := newTestInstance()
 		instance.Metadata[test.key] = test.val
 
-		err := attestor.AttestMetadata(instance, "vault-role", "test")
+		err := attestor.AttestMetadata(instance, "vault-role", test.format, "test")
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
 	}
 }
 
-func TestAttestStatus(t *testing.T) {
+func TestAttestRoleTag(t *testing.T) {
 	var tests = []struct {
-		status string
+		tags   []string
 		result bool
 	}{
-		{"ACTIVE", true},
-		{"STOPPED", false},
+		{[]string{"vault-role=test"}, true},
+		{[]string{"web", "vault-role=test"}, true},
+		{[]string{"vault-role=invalid", "vault-role=test"}, true},
+		{[]string{"vault-role=invalid"}, false},
+		{[]string{"vault-role=test-2"}, false},
+		{[]string{"test"}, false},
+		{[]string{}, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Tags = test.tags
+
+		err := attestor.AttestRoleTag(instance, "vault-role=", "test")
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestServerTags(t *testing.T) {
+	var tests = []struct {
+		tags   []string
+		bound  []string
+		mode   string
+		result bool
+	}{
+		{[]string{}, []string{}, "all", true},
+		{[]string{"web", "prod"}, []string{"web", "prod"}, "all", true},
+		{[]string{"web", "prod"}, []string{"prod"}, "", true},
+		{[]string{"web", "prod"}, []string{"web", "db"}, "all", false},
+		{[]string{}, []string{"web"}, "all", false},
+		{[]string{"web", "prod"}, []string{"db", "prod"}, "any", true},
+		{[]string{"web", "prod"}, []string{"db", "staging"}, "any", false},
+		{[]string{}, []string{"web"}, "any", false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Tags = test.tags
+
+		err := attestor.AttestServerTags(instance, test.bound, test.mode)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestBoundMetadata(t *testing.T) {
+	var tests = []struct {
+		metadata map[string]string
+		bound    map[string][]string
+		result   bool
+	}{
+		{map[string]string{}, map[string][]string{}, true},
+		{map[string]string{"env": "prod"}, map[string][]string{"env": {"prod"}}, true},
+		{map[string]string{"env": "prod"}, map[string][]string{"env": {"staging", "prod"}}, true},
+		{map[string]string{"env": "prod", "team": "payments"}, map[string][]string{"env": {"prod"}, "team": {"payments"}}, true},
+		{map[string]string{"env": "prod-east"}, map[string][]string{"env": {"prod-*"}}, true},
+		{map[string]string{"env": "prod"}, map[string][]string{"env": {"staging"}}, false},
+		{map[string]string{"env": "prod"}, map[string][]string{"env": {"prod"}, "team": {"payments"}}, false},
+		{map[string]string{"env": "prod", "team": "web"}, map[string][]string{"env": {"prod"}, "team": {"payments"}}, false},
+		{map[string]string{"env": "dev-prod"}, map[string][]string{"env": {"prod-*"}}, false},
 	}
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Metadata = test.metadata
+
+		err := attestor.AttestBoundMetadata(instance, test.bound)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestInstanceName(t *testing.T) {
+	var tests = []struct {
+		name        string
+		hostname    string
+		patterns    []string
+		patternType string
+		result      bool
+	}{
+		{"web-prod-1", "", []string{}, "glob", true},
+		{"web-prod-1", "", []string{"web-prod-*"}, "glob", true},
+		{"web-prod-1", "", []string{"db-*", "web-prod-*"}, "", true},
+		{"web-prod-1", "web-prod-1", []string{"web-prod-*"}, "glob", true},
+		{"web-prod-1", "", []string{"^web-prod-[0-9]+$"}, "regex", true},
+		{"web-prod-1", "web-prod-1", []string{"^web-prod-[0-9]+$"}, "regex", true},
+		{"web-dev-1", "", []string{"web-prod-*"}, "glob", false},
+		{"web-prod-1", "web-dev-1", []string{"web-prod-*"}, "glob", false},
+		{"web-prod-x", "", []string{"^web-prod-[0-9]+$"}, "regex", false},
+		{"web-prod-1", "", []string{"("}, "regex", false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Name = test.name
+		instance.Hostname = test.hostname
+
+		err := attestor.AttestInstanceName(instance, test.patterns, test.patternType)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestStatus(t *testing.T) {
+	var tests = []struct {
+		status    string
+		statuses  []string
+		locked    bool
+		vmState   string
+		taskState string
+		result    bool
+	}{
+		{"ACTIVE", []string{}, false, "active", "", true},
+		{"SHUTOFF", []string{}, false, "stopped", "", false},
+		{"SHUTOFF", []string{"ACTIVE", "SHUTOFF"}, false, "stopped", "", true},
+		{"ACTIVE", []string{"SHUTOFF"}, false, "active", "", false},
+		{"ACTIVE", []string{}, true, "active", "", false},
+		{"RESCUE", []string{"RESCUE"}, false, "rescued", "", false},
+		{"SHELVED_OFFLOADED", []string{"SHELVED_OFFLOADED"}, false, "shelved_offloaded", "", false},
+		{"ACTIVE", []string{}, false, "active", "migrating", false},
+		{"ACTIVE", []string{}, false, "", "", true},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
 
 	for _, test := range tests {
 		instance := newTestInstance()
 		instance.Status = test.status
+		instance.Locked = test.locked
+		instance.VmState = test.vmState
+		instance.TaskState = test.taskState
 
-		err := attestor.AttestStatus(instance)
+		err := attestor.AttestStatus(instance, test.statuses)
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
@@ -144,7 +318,7 @@ func TestAttestAddr(t *testing.T) {
 	}
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
 
 	for _, test := range tests {
 		instance := newTestInstance()
@@ -171,23 +345,289 @@ func TestAttestAddr(t *testing.T) {
 	}
 }
 
-func TestAttestTenantID(t *testing.T) {
+func TestAttestAddrIPv6(t *testing.T) {
 	var tests = []struct {
-		tenantID string
-		result   bool
+		access4   string
+		access6   string
+		addresses map[float64][]string
+		addr      string
+		result    bool
 	}{
-		{"", true},
-		{"fcad67a6189847c4aecfa3c81a05783b", true},
+		{"", "2001:db8::1", nil, "2001:db8::1", true},
+		{"", "2001:db8:0:0:0:0:0:1", nil, "2001:db8::1", true},
+		{"", "2001:DB8::1", nil, "2001:db8::1", true},
+		{"", "2001:db8::1", nil, "[2001:db8::1]", true},
+		{"", "fe80::1", nil, "fe80::1%eth0", true},
+		{"", "", map[float64][]string{6: {"2001:db8::1"}}, "2001:db8::1", true},
+		{"", "", map[float64][]string{4: {"192.168.1.1"}, 6: {"2001:db8::1"}}, "2001:db8::1", true},
+		{"", "", map[float64][]string{4: {"192.168.1.1"}, 6: {"2001:db8::1"}}, "192.168.1.1", true},
+		{"192.168.1.1", "", nil, "::ffff:192.168.1.1", true},
+		{"", "", map[float64][]string{4: {"192.168.1.1"}}, "::ffff:c0a8:101", true},
+		{"", "", map[float64][]string{6: {"::ffff:192.168.1.1"}}, "192.168.1.1", true},
+		{"", "2001:db8::2", nil, "2001:db8::1", false},
+		{"", "", map[float64][]string{6: {"2001:db8::2"}}, "2001:db8::1", false},
+		{"", "", map[float64][]string{4: {"192.168.1.1"}}, "2001:db8::1", false},
+		{"", "", map[float64][]string{6: {"2001:db8::1"}}, "192.168.1.1", false},
+		{"", "2001:db8::1", nil, "invalid", false},
+		{"", "", nil, "", false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.AccessIPv4 = test.access4
+		instance.AccessIPv6 = test.access6
+
+		addresses := []interface{}{}
+		for version, addrs := range test.addresses {
+			for _, addr := range addrs {
+				addresses = append(addresses, map[string]interface{}{
+					"OS-EXT-IPS-MAC:mac_addr": "fa:16:3e:9e:89:be",
+					"OS-EXT-IPS:type":         "fixed",
+					"version":                 version,
+					"addr":                    addr,
+				})
+			}
+		}
+		instance.Addresses = map[string]interface{}{
+			"private": addresses,
+		}
+
+		err := attestor.AttestAddr(instance, test.addr)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestPortAddr(t *testing.T) {
+	var tests = []struct {
+		addr   string
+		result bool
+	}{
+		{"192.168.1.1", true},
+		{"2001:db8::1", true},
+		{"192.168.2.10", true},
+		{"192.168.3.1", true},
+		{"203.0.113.1", true},
+		{"192.168.2.11", false},
+		{"10.0.0.1", false},
+		{"192.168.1.2", false},
+		{"192.168.4.1", false},
+		{"203.0.113.2", false},
 		{"invalid", false},
 	}
 
+	mux := http.NewServeMux()
+	mux.HandleFunc("/network/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("device_id") != "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5" {
+			writeTestJSON(w, `{"ports": []}`)
+			return
+		}
+
+		writeTestJSON(w, `{"ports": [
+			{
+				"id": "port1",
+				"device_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+				"fixed_ips": [{"ip_address": "192.168.1.1"}, {"ip_address": "2001:db8::1"}],
+				"allowed_address_pairs": [{"ip_address": "192.168.2.10/32"}, {"ip_address": "192.168.2.0/24"}, {"ip_address": "0.0.0.0/0"}]
+			},
+			{
+				"id": "port2",
+				"device_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+				"fixed_ips": [{"ip_address": "192.168.5.1"}],
+				"allowed_address_pairs": [{"ip_address": "192.168.3.1"}]
+			}
+		]}`)
+	})
+	mux.HandleFunc("/network/v2.0/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("port_id") != "port2" {
+			writeTestJSON(w, `{"floatingips": []}`)
+			return
+		}
+
+		writeTestJSON(w, `{"floatingips": [
+			{"id": "fip1", "port_id": "port2", "floating_ip_address": "203.0.113.1", "fixed_ip_address": "192.168.5.1"}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, client)
 
 	for _, test := range tests {
 		instance := newTestInstance()
 
-		err := attestor.AttestTenantID(instance, test.tenantID)
+		err := attestor.AttestPortAddr(instance, test.addr)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestNetwork(t *testing.T) {
+	var tests = []struct {
+		addr       string
+		networkIDs []string
+		cidrs      []string
+		result     bool
+	}{
+		{"192.168.1.1", []string{}, []string{}, true},
+		{"192.168.1.1", []string{"trusted"}, []string{}, true},
+		{"192.168.1.1", []string{}, []string{"192.168.1.0/24"}, true},
+		{"192.168.1.1", []string{"trusted"}, []string{"10.0.0.0/8", "192.168.1.0/24"}, true},
+		{"192.168.2.10", []string{"trusted"}, []string{"192.168.2.0/24"}, true},
+		{"203.0.113.1", []string{"untrusted"}, []string{"172.16.0.0/24"}, true},
+		{"172.16.0.1", []string{"trusted"}, []string{}, false},
+		{"203.0.113.1", []string{"trusted"}, []string{}, false},
+		{"203.0.113.1", []string{}, []string{"203.0.113.0/24"}, false},
+		{"192.168.1.1", []string{}, []string{"10.0.0.0/8"}, false},
+		{"192.168.9.1", []string{"trusted"}, []string{}, false},
+		{"10.0.0.1", []string{"trusted"}, []string{"10.0.0.0/8"}, false},
+		{"invalid", []string{"trusted"}, []string{}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/network/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"ports": [
+			{
+				"id": "port1",
+				"network_id": "trusted",
+				"device_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+				"fixed_ips": [{"ip_address": "192.168.1.1"}],
+				"allowed_address_pairs": [{"ip_address": "192.168.2.10"}, {"ip_address": "0.0.0.0/0"}]
+			},
+			{
+				"id": "port2",
+				"network_id": "untrusted",
+				"device_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+				"fixed_ips": [{"ip_address": "172.16.0.1"}]
+			}
+		]}`)
+	})
+	mux.HandleFunc("/network/v2.0/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("port_id") != "port2" {
+			writeTestJSON(w, `{"floatingips": []}`)
+			return
+		}
+
+		writeTestJSON(w, `{"floatingips": [
+			{"id": "fip1", "port_id": "port2", "floating_ip_address": "203.0.113.1", "fixed_ip_address": "172.16.0.1"}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+
+		err := attestor.AttestNetwork(instance, test.addr, test.networkIDs, test.cidrs)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+
+	// The network bindings fail without the network client, but the other
+	// roles are not affected.
+	attestor = NewAttestor(storage, &Client{Compute: client.Compute})
+
+	err := attestor.AttestNetwork(newTestInstance(), "192.168.1.1", []string{}, []string{})
+	if err != nil {
+		t.Errorf("unexpected error without network client: %v", err)
+	}
+
+	err = attestor.AttestNetwork(newTestInstance(), "192.168.1.1", []string{"trusted"}, []string{})
+	if err == nil {
+		t.Errorf("expected error without network client")
+	}
+}
+
+func TestAttestSecurityGroup(t *testing.T) {
+	projectIDs := []string{"fcad67a6189847c4aecfa3c81a05783b"}
+
+	var tests = []struct {
+		groups      []map[string]interface{}
+		bound       []string
+		verifyPorts bool
+		projectIDs  []string
+		result      bool
+	}{
+		{[]map[string]interface{}{}, []string{}, false, []string{}, true},
+		{[]map[string]interface{}{{"name": "sg-vault-clients"}}, []string{"sg-vault-clients"}, false, projectIDs, true},
+		{[]map[string]interface{}{{"name": "default"}, {"name": "sg-vault-clients"}}, []string{"sg-vault-clients"}, false, projectIDs, true},
+		{[]map[string]interface{}{{"name": "sg-vault-clients"}}, []string{"sg-vault-clients"}, false, []string{}, false},
+		{[]map[string]interface{}{{"name": "default"}}, []string{"sg-vault-clients"}, false, projectIDs, false},
+		{[]map[string]interface{}{}, []string{"sg-vault-clients"}, false, projectIDs, false},
+		{[]map[string]interface{}{{"name": "sg-vault-clients"}}, []string{"sg2"}, false, projectIDs, false},
+		{[]map[string]interface{}{}, []string{"sg-vault-clients"}, true, projectIDs, true},
+		{[]map[string]interface{}{}, []string{"sg2"}, true, projectIDs, true},
+		{[]map[string]interface{}{}, []string{"sg2"}, true, []string{}, true},
+		{[]map[string]interface{}{}, []string{"default", "sg-vault-clients"}, true, projectIDs, true},
+		{[]map[string]interface{}{}, []string{"sg-vault-clients"}, true, []string{}, false},
+		{[]map[string]interface{}{}, []string{"sg-vault-clients"}, true, []string{"other"}, false},
+		{[]map[string]interface{}{}, []string{"sg-foreign"}, true, projectIDs, false},
+		{[]map[string]interface{}{{"name": "sg-admin"}}, []string{"sg-admin"}, true, projectIDs, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/network/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"ports": [
+			{"id": "port1", "device_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "security_groups": ["sg1", "sg2", "sg3"]}
+		]}`)
+	})
+	mux.HandleFunc("/network/v2.0/security-groups/sg1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"security_group": {"id": "sg1", "name": "default", "project_id": "fcad67a6189847c4aecfa3c81a05783b"}}`)
+	})
+	mux.HandleFunc("/network/v2.0/security-groups/sg2", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"security_group": {"id": "sg2", "name": "sg-vault-clients", "project_id": "fcad67a6189847c4aecfa3c81a05783b"}}`)
+	})
+	mux.HandleFunc("/network/v2.0/security-groups/sg3", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"security_group": {"id": "sg3", "name": "sg-foreign", "project_id": "other"}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.SecurityGroups = test.groups
+
+		err := attestor.AttestSecurityGroup(instance, test.bound, test.verifyPorts, test.projectIDs)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestProjectID(t *testing.T) {
+	var tests = []struct {
+		projectIDs []string
+		result     bool
+	}{
+		{[]string{}, true},
+		{[]string{"fcad67a6189847c4aecfa3c81a05783b"}, true},
+		{[]string{"invalid", "fcad67a6189847c4aecfa3c81a05783b"}, true},
+		{[]string{"invalid"}, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+
+		err := attestor.AttestProjectID(instance, test.projectIDs)
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
@@ -196,27 +636,459 @@ func TestAttestTenantID(t *testing.T) {
 
 func TestAttestUserID(t *testing.T) {
 	var tests = []struct {
-		userID string
+		userIDs []string
+		result  bool
+	}{
+		{[]string{}, true},
+		{[]string{"9349aff8be7545ac9d2f1d00999a23cd"}, true},
+		{[]string{"invalid", "9349aff8be7545ac9d2f1d00999a23cd"}, true},
+		{[]string{"invalid"}, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+
+		err := attestor.AttestUserID(instance, test.userIDs)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestProject(t *testing.T) {
+	var tests = []struct {
+		projectID string
+		parentIDs []string
+		tags      []string
+		domainIDs []string
+		result    bool
+	}{
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{}, []string{}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"parent"}, []string{}, []string{}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"root"}, []string{}, []string{}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{"pci"}, []string{}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{"dev", "prod"}, []string{}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{}, []string{"default"}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"root"}, []string{"prod"}, []string{"default"}, true},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"other"}, []string{}, []string{}, false},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"default"}, []string{}, []string{}, false},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{"fcad67a6189847c4aecfa3c81a05783b"}, []string{}, []string{}, false},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{"dev"}, []string{}, false},
+		{"fcad67a6189847c4aecfa3c81a05783b", []string{}, []string{}, []string{"other"}, false},
+		{"root", []string{"root"}, []string{}, []string{}, false},
+		{"unknown", []string{}, []string{}, []string{"default"}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity/v3/projects/fcad67a6189847c4aecfa3c81a05783b", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"project": {"id": "fcad67a6189847c4aecfa3c81a05783b", "domain_id": "default", "parent_id": "parent", "tags": ["pci", "prod"]}}`)
+	})
+	mux.HandleFunc("/identity/v3/projects/parent", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"project": {"id": "parent", "domain_id": "default", "parent_id": "root", "tags": []}}`)
+	})
+	mux.HandleFunc("/identity/v3/projects/root", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"project": {"id": "root", "domain_id": "default", "parent_id": "default", "tags": []}}`)
+	})
+	mux.HandleFunc("/identity/v3/projects/unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.TenantID = test.projectID
+
+		err := attestor.AttestProject(instance, test.parentIDs, test.tags, test.domainIDs)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestImage(t *testing.T) {
+	var tests = []struct {
+		image      string
+		volumes    []string
+		imageIDs   []string
+		properties map[string]string
+		result     bool
+	}{
+		{"image1", nil, []string{}, map[string]string{}, true},
+		{"image1", nil, []string{"image1"}, map[string]string{}, true},
+		{"image1", nil, []string{"image2", "image1"}, map[string]string{}, true},
+		{"image1", nil, []string{"image1"}, map[string]string{"os_distro": "ubuntu"}, true},
+		{"image1", nil, []string{}, map[string]string{"os_distro": "ubuntu", "hardened": "true"}, true},
+		{"image1", nil, []string{}, map[string]string{"golden_version": "2020.*"}, true},
+		{"image1", nil, []string{"image2"}, map[string]string{}, false},
+		{"image1", nil, []string{}, map[string]string{"os_distro": "centos"}, false},
+		{"image1", nil, []string{}, map[string]string{"golden_version": "2019.*"}, false},
+		{"image1", nil, []string{}, map[string]string{"unknown": "*"}, false},
+		{"image2", nil, []string{}, map[string]string{"os_distro": "ubuntu"}, false},
+		{"", []string{"volume1", "volume2"}, []string{"image1"}, map[string]string{}, true},
+		{"", []string{"volume2", "volume1"}, []string{"image1"}, map[string]string{"hardened": "true"}, true},
+		{"", []string{"volume1", "volume2"}, []string{"image3"}, map[string]string{}, false},
+		{"", []string{"volume1"}, []string{}, map[string]string{"hardened": "false"}, false},
+		{"", []string{"volume3"}, []string{"image1"}, map[string]string{}, false},
+		{"", []string{}, []string{"image1"}, map[string]string{}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image/v2/images/image1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"id": "image1", "name": "ubuntu", "os_distro": "ubuntu", "hardened": "true", "golden_version": "2020.01"}`)
+	})
+	mux.HandleFunc("/image/v2/images/image2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/volume/volumes/volume1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"volume": {
+			"id": "volume1",
+			"bootable": "true",
+			"attachments": [{"server_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "device": "/dev/vda"}],
+			"volume_image_metadata": {"image_id": "image1", "hardened": "true"}
+		}}`)
+	})
+	mux.HandleFunc("/volume/volumes/volume2", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"volume": {
+			"id": "volume2",
+			"bootable": "true",
+			"attachments": [{"server_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "device": "/dev/vdb"}],
+			"volume_image_metadata": {"image_id": "image3", "hardened": "false"}
+		}}`)
+	})
+	mux.HandleFunc("/volume/volumes/volume4", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"volume": {
+			"id": "volume4",
+			"bootable": "true",
+			"attachments": [{"server_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "device": "/dev/sda"}],
+			"volume_image_metadata": {"image_id": "image4"}
+		}}`)
+	})
+	mux.HandleFunc("/volume/volumes/volume3", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"volume": {
+			"id": "volume3",
+			"bootable": "false",
+			"attachments": [{"server_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "device": "/dev/vda"}]
+		}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		if test.image != "" {
+			instance.Image = map[string]interface{}{"id": test.image}
+		}
+		for _, volume := range test.volumes {
+			instance.AttachedVolumes = append(instance.AttachedVolumes, servers.AttachedVolume{ID: volume})
+		}
+
+		err := attestor.AttestImage(instance, test.imageIDs, test.properties)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+
+	// The root volume is resolved by the root device name if available.
+	var rootTests = []struct {
+		rootDevice string
+		volumes    []string
+		imageIDs   []string
+		result     bool
+	}{
+		{"/dev/vda", []string{"volume4", "volume1"}, []string{"image1"}, true},
+		{"/dev/vda", []string{"volume2", "volume1"}, []string{"image1"}, true},
+		{"/dev/sda", []string{"volume4", "volume1"}, []string{"image4"}, true},
+		{"", []string{"volume4", "volume1"}, []string{"image4"}, true},
+		{"/dev/vda", []string{"volume4", "volume1"}, []string{"image4"}, false},
+		{"/dev/sdb", []string{"volume4", "volume1"}, []string{"image1"}, false},
+	}
+
+	for _, test := range rootTests {
+		instance := newTestInstance()
+		instance.RootDeviceName = test.rootDevice
+		for _, volume := range test.volumes {
+			instance.AttachedVolumes = append(instance.AttachedVolumes, servers.AttachedVolume{ID: volume})
+		}
+
+		err := attestor.AttestImage(instance, test.imageIDs, map[string]string{})
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+
+	// The image IDs are verified without the image client, but the image
+	// properties are not.
+	attestor = NewAttestor(storage, &Client{Compute: client.Compute})
+	instance := newTestInstance()
+	instance.Image = map[string]interface{}{"id": "image1"}
+
+	err := attestor.AttestImage(instance, []string{"image1"}, map[string]string{})
+	if err != nil {
+		t.Errorf("unexpected error without image client: %v", err)
+	}
+
+	err = attestor.AttestImage(instance, []string{}, map[string]string{"os_distro": "ubuntu"})
+	if err == nil {
+		t.Errorf("expected error without image client")
+	}
+}
+
+func TestAttestFlavor(t *testing.T) {
+	var tests = []struct {
+		flavor      map[string]interface{}
+		flavorIDs   []string
+		flavorNames []string
+		result      bool
+	}{
+		{map[string]interface{}{"id": "1"}, []string{}, []string{}, true},
+		{map[string]interface{}{"id": "1"}, []string{"1"}, []string{}, true},
+		{map[string]interface{}{"id": "1"}, []string{"2", "1"}, []string{}, true},
+		{map[string]interface{}{"id": "1"}, []string{}, []string{"m1.small"}, true},
+		{map[string]interface{}{"id": "1"}, []string{"1"}, []string{"m1.small"}, true},
+		{map[string]interface{}{"original_name": "m1.small"}, []string{}, []string{"m1.small"}, true},
+		{map[string]interface{}{"id": "1"}, []string{"2"}, []string{}, false},
+		{map[string]interface{}{"id": "1"}, []string{}, []string{"m1.large"}, false},
+		{map[string]interface{}{"id": "2"}, []string{}, []string{"m1.small"}, false},
+		{map[string]interface{}{"original_name": "m1.small"}, []string{"1"}, []string{}, false},
+		{map[string]interface{}{}, []string{}, []string{"m1.small"}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/flavors/1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"flavor": {"id": "1", "name": "m1.small"}}`)
+	})
+	mux.HandleFunc("/compute/flavors/2", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"flavor": {"id": "2", "name": "m1.medium"}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Flavor = test.flavor
+
+		err := attestor.AttestFlavor(instance, test.flavorIDs, test.flavorNames)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestAvailabilityZone(t *testing.T) {
+	var tests = []struct {
+		zone   string
+		zones  []string
 		result bool
 	}{
-		{"", true},
-		{"9349aff8be7545ac9d2f1d00999a23cd", true},
-		{"invalid", false},
+		{"nova", []string{}, true},
+		{"nova", []string{"nova"}, true},
+		{"nova", []string{"pci", "nova"}, true},
+		{"nova", []string{"pci"}, false},
+		{"", []string{"pci"}, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.AvailabilityZone = test.zone
+
+		err := attestor.AttestAvailabilityZone(instance, test.zones)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
 	}
+}
+
+func TestAttestHostAggregate(t *testing.T) {
+	var tests = []struct {
+		host   string
+		aggrs  []string
+		result bool
+	}{
+		{"compute1", []string{}, true},
+		{"compute1", []string{"pci"}, true},
+		{"compute1", []string{"1"}, true},
+		{"compute3", []string{"general", "pci"}, true},
+		{"compute3", []string{"pci"}, false},
+		{"compute1", []string{"2"}, false},
+		{"compute1", []string{"unknown"}, false},
+		{"", []string{"pci"}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/os-aggregates", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"aggregates": [
+			{"id": 1, "name": "pci", "hosts": ["compute1", "compute2"]},
+			{"id": 2, "name": "general", "hosts": ["compute3"]}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, client)
 
 	for _, test := range tests {
 		instance := newTestInstance()
+		instance.Host = test.host
 
-		err := attestor.AttestUserID(instance, test.userID)
+		err := attestor.AttestHostAggregate(instance, test.aggrs)
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
 	}
 }
 
+func TestAttestKeyPair(t *testing.T) {
+	var tests = []struct {
+		keyName      string
+		names        []string
+		fingerprints []string
+		result       bool
+	}{
+		{"", []string{}, []string{}, true},
+		{"deploy", []string{"deploy"}, []string{}, true},
+		{"deploy", []string{}, []string{"1e:2c:9b:56:79:4b:45:77:f9:ca:7a:98:2c:b0:d5:3c"}, true},
+		{"deploy", []string{"deploy"}, []string{"1e:2c:9b:56:79:4b:45:77:f9:ca:7a:98:2c:b0:d5:3c"}, true},
+		{"", []string{"deploy"}, []string{}, false},
+		{"other", []string{"deploy"}, []string{}, false},
+		{"deploy", []string{}, []string{"00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00"}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/os-keypairs/deploy", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"keypair": {"name": "deploy", "fingerprint": "1e:2c:9b:56:79:4b:45:77:f9:ca:7a:98:2c:b0:d5:3c"}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+	client.Compute.Microversion = "2.26"
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.KeyName = test.keyName
+
+		err := attestor.AttestKeyPair(instance, test.names, test.fingerprints)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestServerGroup(t *testing.T) {
+	var tests = []struct {
+		groupIDs []string
+		result   bool
+	}{
+		{[]string{}, true},
+		{[]string{"group1"}, true},
+		{[]string{"group2", "group1"}, true},
+		{[]string{"unknown", "group1"}, true},
+		{[]string{"group2"}, false},
+		{[]string{"unknown"}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/os-server-groups/group1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"server_group": {"id": "group1", "policies": ["anti-affinity"], "members": ["ef079b0c-e610-4dfb-b1aa-b49f07ac48e5"]}}`)
+	})
+	mux.HandleFunc("/compute/os-server-groups/group2", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"server_group": {"id": "group2", "policies": ["anti-affinity"], "members": ["2ce4d8fb-2c5c-4b8e-a3b3-7c0e7a2d2f4b"]}}`)
+	})
+	mux.HandleFunc("/compute/os-server-groups/unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+
+		err := attestor.AttestServerGroup(instance, test.groupIDs)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestGetAuthPeriodStart(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	launched := time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)
+	rebuilt := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		start    string
+		launched time.Time
+		expected time.Time
+		result   bool
+	}{
+		{"", launched, created, true},
+		{"created", launched, created, true},
+		{"launched", launched, launched, true},
+		{"launched", time.Time{}, time.Time{}, false},
+		{"rebuilt", launched, rebuilt, true},
+		{"invalid", launched, time.Time{}, false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5/os-instance-actions", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"instanceActions": [
+			{"action": "stop", "start_time": "2020-01-03T00:00:00.000000"},
+			{"action": "rebuild", "start_time": "2020-01-02T00:00:00.000000"},
+			{"action": "rebuild", "start_time": "2020-01-01T12:00:00.000000"},
+			{"action": "create", "start_time": "2020-01-01T00:00:00.000000"}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.Created = created
+		instance.LaunchedAt = test.launched
+
+		start, err := attestor.GetAuthPeriodStart(instance, test.start)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+			continue
+		}
+
+		if !start.Equal(test.expected) {
+			t.Errorf("unexpected start: %v - %s", test, start)
+		}
+	}
+}
+
 func TestVerifyAuthPeriod(t *testing.T) {
 	var tests = []struct {
 		diff   int
@@ -230,40 +1102,322 @@ func TestVerifyAuthPeriod(t *testing.T) {
 	}
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		start := time.Now().Add(time.Duration(test.diff) * time.Second)
+		period := time.Duration(test.period) * time.Second
+
+		_, err := attestor.VerifyAuthPeriod(start, period)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestVerifyMinInstanceAge(t *testing.T) {
+	var tests = []struct {
+		diff   int
+		minAge int
+		result bool
+	}{
+		{0, 0, true},
+		{-31, 30, true},
+		{-29, 30, false},
+		{0, 30, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		start := time.Now().Add(time.Duration(test.diff) * time.Second)
+		minAge := time.Duration(test.minAge) * time.Second
+
+		err := attestor.VerifyMinInstanceAge(start, minAge)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestVerifyMaxInstanceAge(t *testing.T) {
+	var tests = []struct {
+		diff   int
+		maxAge int
+		result bool
+	}{
+		{-3600, 0, true},
+		{-60, 120, true},
+		{-121, 120, false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
 
 	for _, test := range tests {
 		instance := newTestInstance()
 		instance.Created = time.Now().Add(time.Duration(test.diff) * time.Second)
-		period := time.Duration(test.period) * time.Second
+		maxAge := time.Duration(test.maxAge) * time.Second
+
+		remaining, err := attestor.VerifyMaxInstanceAge(instance, maxAge)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+
+		if remaining > maxAge {
+			t.Errorf("unexpected remaining age: %v - %s", test, remaining)
+		}
+	}
+}
+
+func TestAttestInstanceActions(t *testing.T) {
+	var tests = []struct {
+		denied []string
+		cutoff string
+		result bool
+	}{
+		{[]string{}, "", true},
+		{[]string{}, "2020-01-03T00:00:00Z", true},
+		{[]string{}, "2020-01-01T12:00:00Z", false},
+		{[]string{"evacuate"}, "2020-01-01T12:00:00Z", true},
+		{[]string{"stop"}, "2020-01-01T12:00:00Z", false},
+		{[]string{}, "invalid", false},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5/os-instance-actions", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"instanceActions": [
+			{"action": "stop", "start_time": "2020-01-02T12:00:00.000000"},
+			{"action": "rebuild", "start_time": "2020-01-02T00:00:00.000000"},
+			{"action": "create", "start_time": "2020-01-01T00:00:00.000000"}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, client)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+
+		err := attestor.AttestInstanceActions(instance, test.denied, test.cutoff)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestAttestUserDataToken(t *testing.T) {
+	hash := hashSecret("test")
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("#cloud-config\n# vault-token-hash=" + hash + "\n"))
+	w.Close()
+
+	var tests = []struct {
+		userData string
+		marker   string
+		token    string
+		result   bool
+	}{
+		{"", "", "", true},
+		{base64.StdEncoding.EncodeToString([]byte("#cloud-config\n# vault-token-hash=" + hash + "\n")), "vault-token-hash=", "test", true},
+		{base64.StdEncoding.EncodeToString([]byte("vault-token-hash=" + strings.ToUpper(hash))), "vault-token-hash=", "test", true},
+		{base64.StdEncoding.EncodeToString(buf.Bytes()), "vault-token-hash=", "test", true},
+		{base64.StdEncoding.EncodeToString([]byte("vault-token-hash=" + hash)), "vault-token-hash=", "invalid", false},
+		{base64.StdEncoding.EncodeToString([]byte("vault-token-hash=" + hash)), "vault-token-hash=", "", false},
+		{base64.StdEncoding.EncodeToString([]byte("vault-token-hash=" + hash)), "other=", "test", false},
+		{base64.StdEncoding.EncodeToString([]byte("vault-token-hash=abcd")), "vault-token-hash=", "test", false},
+		{"", "vault-token-hash=", "test", false},
+		{"invalid!", "vault-token-hash=", "test", false},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for _, test := range tests {
+		instance := newTestInstance()
+		instance.UserData = test.userData
+
+		err := attestor.AttestUserDataToken(instance, test.marker, test.token)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+		}
+	}
+}
+
+func TestVerifyIdentityNonce(t *testing.T) {
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	doc := &IdentityDocument{
+		InstanceID: "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+		ProjectID:  "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d",
+		Nonce:      "test",
+		Timestamp:  time.Now(),
+	}
 
-		_, err := attestor.VerifyAuthPeriod(instance, period)
+	err := attestor.VerifyIdentityNonce(doc, 60*time.Second)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = attestor.VerifyIdentityNonce(doc, 60*time.Second)
+	if err == nil {
+		t.Errorf("expected error with replayed document")
+	}
+
+	doc.Nonce = "other"
+	err = attestor.VerifyIdentityNonce(doc, 60*time.Second)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestVerifySecrets(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestInstance()
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	err := attestor.VerifySecrets(instance, map[string]string{SecretKindVendordata: "test"})
+	if err == nil {
+		t.Errorf("expected error without secret")
+	}
+
+	err = updateSecret(ctx, storage, SecretKindVendordata, &Secret{
+		Name:     instance.ID,
+		Hash:     hashSecret("test"),
+		Deadline: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tests = []struct {
+		secret string
+		result bool
+	}{
+		{"", false},
+		{"invalid", false},
+		{"test", true},
+		{"test", false},
+	}
+
+	for _, test := range tests {
+		err := attestor.VerifySecrets(instance, map[string]string{SecretKindVendordata: test.secret})
 		if (err == nil) != test.result {
 			t.Errorf("unexpected result: %v - %v", test, err)
 		}
 	}
+
+	err = updateSecret(ctx, storage, SecretKindVendordata, &Secret{
+		Name:     instance.ID,
+		Hash:     hashSecret("test"),
+		Deadline: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = attestor.VerifySecrets(instance, map[string]string{SecretKindVendordata: "test"})
+	if err == nil {
+		t.Errorf("expected error with expired secret")
+	}
+
+	for _, kind := range []string{SecretKindVendordata, SecretKindChallenge} {
+		err = updateSecret(ctx, storage, kind, &Secret{
+			Name:     secretName(kind, instance.ID, "test"),
+			Hash:     hashSecret("test"),
+			Deadline: time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err = attestor.VerifySecrets(instance, map[string]string{
+		SecretKindVendordata: "test",
+		SecretKindChallenge:  "invalid",
+	})
+	if err == nil {
+		t.Errorf("expected error with invalid challenge response")
+	}
+
+	// The valid secret must not be consumed by the failed verification.
+	err = attestor.VerifySecrets(instance, map[string]string{
+		SecretKindVendordata: "test",
+		SecretKindChallenge:  "test",
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, kind := range []string{SecretKindVendordata, SecretKindChallenge} {
+		secret, err := readSecret(ctx, storage, kind, secretName(kind, instance.ID, "test"))
+		if err != nil || secret == nil || !secret.Used {
+			t.Errorf("unexpected secret: %s - %v - %v", kind, secret, err)
+		}
+	}
 }
 
 func TestVerifyAuthLimit(t *testing.T) {
 	instance := newTestInstance()
 	limit := 2
-	deadline := time.Now().Add(30 * time.Second)
+	start := time.Now()
+	deadline := start.Add(30 * time.Second)
 
 	_, storage := newTestBackend(t)
-	attestor := NewAttestor(storage)
+	attestor := NewAttestor(storage, nil)
 
-	count, err := attestor.VerifyAuthLimit(instance, limit, deadline)
+	count, err := attestor.VerifyAuthLimit(instance, limit, start, deadline)
 	if count != 1 || err != nil {
 		t.Errorf("unexpected result: [%d] %v", count, err)
 	}
 
-	count, err = attestor.VerifyAuthLimit(instance, limit, deadline)
+	count, err = attestor.VerifyAuthLimit(instance, limit, start, deadline)
 	if count != 2 || err != nil {
 		t.Errorf("unexpected result: [%d] %v", count, err)
 	}
 
-	count, err = attestor.VerifyAuthLimit(instance, limit, deadline)
+	count, err = attestor.VerifyAuthLimit(instance, limit, start, deadline)
 	if count != 3 || err == nil {
 		t.Errorf("unexpected result: [%d]", count)
 	}
+
+	rebuilt := deadline.Add(time.Second)
+	count, err = attestor.VerifyAuthLimit(instance, limit, rebuilt, rebuilt.Add(30*time.Second))
+	if count != 1 || err != nil {
+		t.Errorf("unexpected result after rebuild: [%d] %v", count, err)
+	}
+}
+
+func TestVerifyAuthLimitWithRoles(t *testing.T) {
+	instance := newTestInstance()
+	limit := 3
+
+	// Two roles of the same instance with the different start and period.
+	roles := []struct {
+		start    time.Time
+		deadline time.Time
+	}{
+		{instance.Created, instance.Created.Add(120 * time.Second)},
+		{instance.Created.Add(5 * time.Second), instance.Created.Add(5 * time.Minute)},
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, nil)
+
+	for i := 0; i < limit*2; i++ {
+		role := roles[i%len(roles)]
+		count, err := attestor.VerifyAuthLimit(instance, limit, role.start, role.deadline)
+		if count != i+1 || (err == nil) != (count <= limit) {
+			t.Errorf("unexpected result: [%d] %v", count, err)
+		}
+	}
 }
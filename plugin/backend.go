@@ -18,10 +18,20 @@ const (
 
 type OpenStackAuthBackend struct {
 	*framework.Backend
-	client      *gophercloud.ServiceClient
+	client      *Client
 	clientMutex sync.RWMutex
 }
 
+// Client is a set of OpenStack service clients used by the backend.
+type Client struct {
+	Compute      *gophercloud.ServiceClient
+	Network      *gophercloud.ServiceClient
+	Image        *gophercloud.ServiceClient
+	BlockStorage *gophercloud.ServiceClient
+	Identity     *gophercloud.ServiceClient
+	Projects     *ProjectCache
+}
+
 func NewBackend() *OpenStackAuthBackend {
 	b := &OpenStackAuthBackend{}
 
@@ -32,10 +42,10 @@ func NewBackend() *OpenStackAuthBackend {
 		AuthRenew:    b.authRenewHandler,
 		Help:         help,
 		PathsSpecial: &logical.Paths{
-			Unauthenticated: []string{"login"},
-			SealWrapStorage: []string{"config"},
+			Unauthenticated: []string{"login", "login/challenge", "vendordata"},
+			SealWrapStorage: []string{"config", "secret_cache/"},
 		},
-		Paths: framework.PathAppend(NewPathConfig(b), NewPathRole(b), NewPathLogin(b)),
+		Paths: framework.PathAppend(NewPathConfig(b), NewPathRole(b), NewPathLogin(b), NewPathChallenge(b), NewPathVendordata(b)),
 	}
 
 	return b
@@ -48,7 +58,7 @@ func (b *OpenStackAuthBackend) Close() {
 	b.client = nil
 }
 
-func (b *OpenStackAuthBackend) getClient(ctx context.Context, s logical.Storage) (*gophercloud.ServiceClient, error) {
+func (b *OpenStackAuthBackend) getClient(ctx context.Context, s logical.Storage) (*Client, error) {
 	b.clientMutex.RLock()
 	if b.client != nil {
 		defer b.clientMutex.RUnlock()
@@ -100,12 +110,59 @@ func (b *OpenStackAuthBackend) getClient(ctx context.Context, s logical.Storage)
 		return nil, err
 	}
 
-	client, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{})
+	compute, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{})
 	if err != nil {
 		return nil, err
 	}
 
-	b.client = client
+	// The features that require newer microversion are disabled
+	// if the negotiation fails.
+	microversion, err := negotiateMicroversion(compute, computeMicroversion)
+	if err != nil {
+		b.Logger().Warn("failed to negotiate compute API microversion", "error", err)
+	}
+	compute.Microversion = microversion
+
+	// Network is optional since it is used only by the Neutron based
+	// attestations.
+	network, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		b.Logger().Warn("network service is not available", "error", err)
+		network = nil
+	}
+
+	// Image is optional since it is used only to fetch the image
+	// properties.
+	image, err := openstack.NewImageServiceV2(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		b.Logger().Warn("image service is not available", "error", err)
+		image = nil
+	}
+
+	// Block storage is optional since it is used only to resolve the image
+	// of the instances booted from volume.
+	blockStorage, err := openstack.NewBlockStorageV3(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		b.Logger().Warn("block storage service is not available", "error", err)
+		blockStorage = nil
+	}
+
+	// Identity is optional since it is used only by the project bindings
+	// and the vendordata endpoint.
+	identity, err := openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		b.Logger().Warn("identity service is not available", "error", err)
+		identity = nil
+	}
+
+	b.client = &Client{
+		Compute:      compute,
+		Network:      network,
+		Image:        image,
+		BlockStorage: blockStorage,
+		Identity:     identity,
+		Projects:     NewProjectCache(identity),
+	}
 
 	return b.client, nil
 }
@@ -127,6 +184,37 @@ func (b *OpenStackAuthBackend) periodicHandler(ctx context.Context, req *logical
 		b.Logger().Info(fmt.Sprintf("%d expired auth attempts has been removed", count))
 	}
 
+	count, err = cleanupIdentityNonce(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		b.Logger().Info(fmt.Sprintf("%d expired identity document nonces has been removed", count))
+	}
+
+	for _, kind := range secretKinds {
+		count, err = cleanupSecret(ctx, req.Storage, kind)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			b.Logger().Info(fmt.Sprintf("%d expired %s secrets has been removed", count, kind))
+		}
+	}
+
+	// The failure of the push is not returned so that it does not prevent
+	// the cleanup in the next period.
+	count, err = b.pushSecrets(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Warn("failed to push secrets", "error", err)
+	}
+
+	if count > 0 {
+		b.Logger().Info(fmt.Sprintf("%d secrets has been pushed", count))
+	}
+
 	return nil
 }
 
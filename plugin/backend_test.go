@@ -2,9 +2,13 @@ package plugin
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -27,3 +31,41 @@ func newTestBackend(t *testing.T) (logical.Backend, logical.Storage) {
 
 	return b, config.StorageView
 }
+
+func newTestClient(t *testing.T, mux *http.ServeMux) (*Client, *httptest.Server) {
+	server := httptest.NewServer(mux)
+	provider := &gophercloud.ProviderClient{TokenID: "test"}
+
+	client := &Client{
+		Compute: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + "/compute/",
+		},
+		Network: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + "/network/",
+			ResourceBase:   server.URL + "/network/v2.0/",
+		},
+		Image: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + "/image/",
+			ResourceBase:   server.URL + "/image/v2/",
+		},
+		BlockStorage: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + "/volume/",
+		},
+		Identity: &gophercloud.ServiceClient{
+			ProviderClient: provider,
+			Endpoint:       server.URL + "/identity/v3/",
+		},
+	}
+	client.Projects = NewProjectCache(client.Identity)
+
+	return client, server
+}
+
+func writeTestJSON(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, body)
+}
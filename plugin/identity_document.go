@@ -0,0 +1,189 @@
+package plugin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// defaultIdentityDocumentClockSkew is the allowed clock skew of the
+// identity document if identity_document_clock_skew is not specified.
+const defaultIdentityDocumentClockSkew = 60 * time.Second
+
+// IdentityDocument is a signed JSON document that identifies OpenStack
+// instance. The document is produced by a trusted signing service.
+type IdentityDocument struct {
+	InstanceID string    `json:"instance_id"`
+	ProjectID  string    `json:"project_id"`
+	Nonce      string    `json:"nonce"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// IdentityNonce is a nonce of the identity document that has been used.
+// It is kept until the identity document expires to reject the replay.
+type IdentityNonce struct {
+	Name     string    `json:"name" structs:"name" mapstructure:"name"`
+	Deadline time.Time `json:"deadline" structs:"deadline" mapstructure:"deadline"`
+}
+
+// parseIdentityDocumentKeys parses the trusted public keys in PEM format
+// and the JSON Web Key Set.
+func parseIdentityDocumentKeys(pemKeys []string, jwks string) ([]interface{}, error) {
+	keys := []interface{}{}
+
+	for _, pemKey := range pemKeys {
+		block, _ := pem.Decode([]byte(pemKey))
+		if block == nil {
+			return nil, errors.New("invalid PEM public key")
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, cert.PublicKey)
+		default:
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	if jwks != "" {
+		var set jose.JSONWebKeySet
+		err := json.Unmarshal([]byte(jwks), &set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS: %v", err)
+		}
+
+		for _, key := range set.Keys {
+			if !key.IsPublic() {
+				return nil, errors.New("JWKS must contain only public keys")
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// verifyIdentityDocument verifies the signature of the identity document
+// in the JWS compact or JSON serialization with the trusted keys, and
+// verifies the timestamp with the allowed clock skew.
+func verifyIdentityDocument(signed string, keys []interface{}, skew time.Duration) (*IdentityDocument, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no trusted keys are configured")
+	}
+
+	jws, err := jose.ParseSigned(signed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity document: %v", err)
+	}
+
+	var payload []byte
+	for _, key := range keys {
+		payload, err = jws.Verify(key)
+		if err == nil {
+			break
+		}
+	}
+
+	if payload == nil {
+		return nil, errors.New("identity document signature mismatched")
+	}
+
+	doc := &IdentityDocument{}
+	err = json.Unmarshal(payload, doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity document: %v", err)
+	}
+
+	if doc.InstanceID == "" || doc.ProjectID == "" || doc.Nonce == "" || doc.Timestamp.IsZero() {
+		return nil, errors.New("identity document is incomplete")
+	}
+
+	if skew <= 0 {
+		skew = defaultIdentityDocumentClockSkew
+	}
+
+	now := time.Now()
+	if doc.Timestamp.Before(now.Add(-skew)) || doc.Timestamp.After(now.Add(skew)) {
+		return nil, errors.New("identity document expired")
+	}
+
+	return doc, nil
+}
+
+func readIdentityNonce(ctx context.Context, s logical.Storage, name string) (*IdentityNonce, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("identity_nonce/%s", name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	nonce := &IdentityNonce{}
+	err = entry.DecodeJSON(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+func updateIdentityNonce(ctx context.Context, s logical.Storage, nonce *IdentityNonce) error {
+	if nonce.Name == "" {
+		return errors.New("invalid nonce name")
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("identity_nonce/%s", nonce.Name), nonce)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func cleanupIdentityNonce(ctx context.Context, s logical.Storage) (int, error) {
+	count := 0
+
+	keys, err := s.List(ctx, "identity_nonce/")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		nonce, err := readIdentityNonce(ctx, s, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if time.Now().After(nonce.Deadline) {
+			err := s.Delete(ctx, fmt.Sprintf("identity_nonce/%s", key))
+			if err != nil {
+				return 0, err
+			}
+			count += 1
+		}
+	}
+
+	return count, nil
+}
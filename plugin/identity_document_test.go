@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+func signTestDocument(t *testing.T, key *ecdsa.PrivateKey, doc map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	signed, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return signed
+}
+
+func newTestECKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestParseIdentityDocumentKeys(t *testing.T) {
+	key, pemKey := newTestECKey(t)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	private, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: key, KeyID: "test"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tests = []struct {
+		pemKeys []string
+		jwks    string
+		count   int
+		result  bool
+	}{
+		{[]string{}, "", 0, true},
+		{[]string{pemKey}, "", 1, true},
+		{[]string{}, string(jwks), 1, true},
+		{[]string{pemKey}, string(jwks), 2, true},
+		{[]string{"invalid"}, "", 0, false},
+		{[]string{}, "invalid", 0, false},
+		{[]string{}, string(private), 0, false},
+	}
+
+	for _, test := range tests {
+		keys, err := parseIdentityDocumentKeys(test.pemKeys, test.jwks)
+		if (err == nil) != test.result || len(keys) != test.count {
+			t.Errorf("unexpected result: %v - %d, %v", test, len(keys), err)
+		}
+	}
+}
+
+func TestVerifyIdentityDocument(t *testing.T) {
+	key, pemKey := newTestECKey(t)
+	otherKey, _ := newTestECKey(t)
+
+	keys, err := parseIdentityDocumentKeys([]string{pemKey}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newDoc := func(diff time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"instance_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			"project_id":  "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d",
+			"nonce":       "test",
+			"timestamp":   time.Now().Add(diff).UTC().Format(time.RFC3339),
+		}
+	}
+
+	incomplete := newDoc(0)
+	delete(incomplete, "nonce")
+
+	var tests = []struct {
+		signed string
+		keys   []interface{}
+		result bool
+	}{
+		{signTestDocument(t, key, newDoc(0)), keys, true},
+		{signTestDocument(t, key, newDoc(-30*time.Second)), keys, true},
+		{signTestDocument(t, key, newDoc(-90*time.Second)), keys, false},
+		{signTestDocument(t, key, newDoc(90*time.Second)), keys, false},
+		{signTestDocument(t, otherKey, newDoc(0)), keys, false},
+		{signTestDocument(t, key, newDoc(0)), []interface{}{}, false},
+		{signTestDocument(t, key, incomplete), keys, false},
+		{"invalid", keys, false},
+	}
+
+	for i, test := range tests {
+		doc, err := verifyIdentityDocument(test.signed, test.keys, 60*time.Second)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: [%d] %v", i, err)
+			continue
+		}
+
+		if err == nil && doc.InstanceID != "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5" {
+			t.Errorf("unexpected document: %v", doc)
+		}
+	}
+}
+
+func TestCleanupIdentityNonce(t *testing.T) {
+	ctx := context.Background()
+	_, storage := newTestBackend(t)
+
+	nonces := []*IdentityNonce{
+		{Name: "expired", Deadline: time.Now().Add(-time.Minute)},
+		{Name: "valid", Deadline: time.Now().Add(time.Minute)},
+	}
+
+	for _, nonce := range nonces {
+		err := updateIdentityNonce(ctx, storage, nonce)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	count, err := cleanupIdentityNonce(ctx, storage)
+	if count != 1 || err != nil {
+		t.Errorf("unexpected result: [%d] %v", count, err)
+	}
+
+	nonce, err := readIdentityNonce(ctx, storage, "valid")
+	if nonce == nil || err != nil {
+		t.Errorf("unexpected result: %v - %v", nonce, err)
+	}
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedserverattributes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/serverusage"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/tags"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+// Instance is an OpenStack instance with the extended attributes.
+// Note that some of the extended attributes are visible only to
+// the administrator by default.
+type Instance struct {
+	servers.Server
+	availabilityzones.ServerAvailabilityZoneExt
+	extendedserverattributes.ServerAttributesExt
+	extendedstatus.ServerExtendedStatusExt
+	serverusage.UsageExt
+	ServerExt
+
+	// Tags is the server tags of the instance. This is set only if
+	// the tags are fetched with getInstanceTags.
+	Tags []string `json:"-"`
+}
+
+// ServerExt is the extended attributes of the instance that are not
+// supported by gophercloud. Some of them require the newer compute API
+// microversion.
+type ServerExt struct {
+	// Hostname is the hostname of the instance. This requires the compute
+	// API microversion 2.3 or later.
+	Hostname string `json:"OS-EXT-SRV-ATTR:hostname"`
+
+	// UserData is the base64 encoded user data of the instance. This
+	// requires the compute API microversion 2.3 or later.
+	UserData string `json:"OS-EXT-SRV-ATTR:user_data"`
+
+	// RootDeviceName is the device name of the root disk of the instance.
+	// This requires the compute API microversion 2.3 or later.
+	RootDeviceName string `json:"OS-EXT-SRV-ATTR:root_device_name"`
+
+	// Locked is true if the instance is locked. This requires the compute
+	// API microversion 2.9 or later.
+	Locked bool `json:"locked"`
+}
+
+// InstanceAction is an action performed on the instance, which is recorded
+// in the instance action log of the compute API.
+type InstanceAction struct {
+	Action    string    `json:"action"`
+	RequestID string    `json:"request_id"`
+	UserID    string    `json:"user_id"`
+	ProjectID string    `json:"project_id"`
+	StartTime time.Time `json:"-"`
+}
+
+// UnmarshalJSON parses the start time of the instance action.
+func (a *InstanceAction) UnmarshalJSON(b []byte) error {
+	type tmp InstanceAction
+	var s struct {
+		tmp
+		StartTime gophercloud.JSONRFC3339MilliNoZ `json:"start_time"`
+	}
+
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	*a = InstanceAction(s.tmp)
+	a.StartTime = time.Time(s.StartTime)
+
+	return nil
+}
+
+// getInstance returns the OpenStack instance with the extended attributes.
+func getInstance(client *gophercloud.ServiceClient, id string) (*Instance, error) {
+	instance := &Instance{}
+
+	err := servers.Get(client, id).ExtractInto(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// getInstanceTags returns the server tags of the OpenStack instance.
+// The server tags require the compute API microversion 2.26 or later.
+func getInstanceTags(client *gophercloud.ServiceClient, id string) ([]string, error) {
+	ok, err := microversionAtLeast(client.Microversion, "2.26")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("server tags require compute API microversion 2.26 or later: current microversion is '%s'", client.Microversion)
+	}
+
+	return tags.List(client, id).Extract()
+}
+
+// listInstanceActions returns the instance action log of the instance.
+// gophercloud does not support the instance actions API, so the request is
+// sent directly.
+func listInstanceActions(client *gophercloud.ServiceClient, id string) ([]InstanceAction, error) {
+	var body struct {
+		InstanceActions []InstanceAction `json:"instanceActions"`
+	}
+
+	_, err := client.Get(client.ServiceURL("servers", id, "os-instance-actions"), &body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.InstanceActions, nil
+}
+
+// getInstanceKeyPair returns the keypair of the instance. The keypair is
+// owned by the user who launched the instance, so the compute API
+// microversion 2.10 or later is required to look it up with the user ID.
+func getInstanceKeyPair(client *gophercloud.ServiceClient, instance *Instance) (*keypairs.KeyPair, error) {
+	if instance.KeyName == "" {
+		return nil, errors.New("instance has no keypair")
+	}
+
+	ok, err := microversionAtLeast(client.Microversion, "2.10")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("keypair lookup requires compute API microversion 2.10 or later: current microversion is '%s'", client.Microversion)
+	}
+
+	u := client.ServiceURL("os-keypairs", instance.KeyName) + "?user_id=" + url.QueryEscape(instance.UserID)
+
+	var r keypairs.GetResult
+	_, r.Err = client.Get(u, &r.Body, nil)
+
+	return r.Extract()
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetInstance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"server": {
+			"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			"name": "test",
+			"status": "ACTIVE",
+			"image": "",
+			"flavor": {"id": "1"},
+			"metadata": {"vault-role": "test"},
+			"created": "2020-01-01T00:00:00Z",
+			"updated": "2020-01-01T00:00:00Z",
+			"OS-EXT-AZ:availability_zone": "nova",
+			"OS-EXT-SRV-ATTR:host": "compute1",
+			"OS-EXT-SRV-ATTR:hostname": "test",
+			"OS-SRV-USG:launched_at": "2020-01-01T00:01:00.000000",
+			"OS-EXT-STS:vm_state": "active",
+			"OS-EXT-STS:task_state": null,
+			"locked": true,
+			"OS-EXT-SRV-ATTR:user_data": "I2Nsb3VkLWNvbmZpZw=="
+		}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	instance, err := getInstance(client.Compute, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if instance.ID != "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5" || instance.Metadata["vault-role"] != "test" || instance.Image != nil {
+		t.Errorf("unexpected server: %v", instance.Server)
+	}
+	if instance.AvailabilityZone != "nova" {
+		t.Errorf("unexpected availability zone: %s", instance.AvailabilityZone)
+	}
+	if instance.Host != "compute1" {
+		t.Errorf("unexpected host: %s", instance.Host)
+	}
+	if instance.Hostname != "test" {
+		t.Errorf("unexpected hostname: %s", instance.Hostname)
+	}
+	if instance.VmState != "active" || instance.TaskState != "" {
+		t.Errorf("unexpected state: %s - %s", instance.VmState, instance.TaskState)
+	}
+	if instance.UserData != "I2Nsb3VkLWNvbmZpZw==" {
+		t.Errorf("unexpected user data: %s", instance.UserData)
+	}
+	if !instance.Locked {
+		t.Errorf("unexpected locked: %v", instance.Locked)
+	}
+	if !instance.LaunchedAt.Equal(time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)) {
+		t.Errorf("unexpected launched time: %s", instance.LaunchedAt)
+	}
+}
+
+func TestGetInstanceTags(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5/tags", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"tags": ["web", "vault-role=test"]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	_, err := getInstanceTags(client.Compute, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err == nil {
+		t.Errorf("expected error without microversion")
+	}
+
+	client.Compute.Microversion = "2.26"
+	tags, err := getInstanceTags(client.Compute, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(tags, []string{"web", "vault-role=test"}) {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestGetInstanceKeyPair(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/os-keypairs/deploy", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("user_id") != "9349aff8be7545ac9d2f1d00999a23cd" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeTestJSON(w, `{"keypair": {
+			"name": "deploy",
+			"fingerprint": "1e:2c:9b:56:79:4b:45:77:f9:ca:7a:98:2c:b0:d5:3c",
+			"user_id": "9349aff8be7545ac9d2f1d00999a23cd"
+		}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	instance := newTestInstance()
+	instance.KeyName = "deploy"
+
+	_, err := getInstanceKeyPair(client.Compute, instance)
+	if err == nil {
+		t.Errorf("expected error without microversion")
+	}
+
+	client.Compute.Microversion = "2.10"
+	keypair, err := getInstanceKeyPair(client.Compute, instance)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if keypair.Fingerprint != "1e:2c:9b:56:79:4b:45:77:f9:ca:7a:98:2c:b0:d5:3c" {
+		t.Errorf("unexpected fingerprint: %s", keypair.Fingerprint)
+	}
+
+	instance.KeyName = ""
+	_, err = getInstanceKeyPair(client.Compute, instance)
+	if err == nil {
+		t.Errorf("expected error without keypair")
+	}
+}
+
+func TestListInstanceActions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5/os-instance-actions", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"instanceActions": [
+			{"action": "rebuild", "request_id": "req-2", "start_time": "2020-01-02T00:00:00.000000"},
+			{"action": "create", "request_id": "req-1", "start_time": "2020-01-01T00:00:00.000000"}
+		]}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	actions, err := listInstanceActions(client.Compute, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(actions) != 2 || actions[0].Action != "rebuild" || actions[0].RequestID != "req-2" {
+		t.Fatalf("unexpected actions: %v", actions)
+	}
+	if !actions[0].StartTime.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected start time: %s", actions[0].StartTime)
+	}
+}
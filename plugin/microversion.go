@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// computeMicroversion is the compute API microversion required by the
+// backend. The compute client uses this microversion if the compute
+// service supports it.
+const computeMicroversion = "2.26"
+
+// negotiateMicroversion returns the newest microversion that is supported
+// by both of the service and the backend. It returns empty string if the
+// service does not support microversions.
+func negotiateMicroversion(client *gophercloud.ServiceClient, want string) (string, error) {
+	var res struct {
+		Version struct {
+			Version    string `json:"version"`
+			MinVersion string `json:"min_version"`
+		} `json:"version"`
+	}
+
+	_, err := client.Get(client.Endpoint, &res, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if res.Version.Version == "" {
+		return "", nil
+	}
+
+	ok, err := microversionAtLeast(res.Version.Version, want)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return res.Version.Version, nil
+	}
+
+	return want, nil
+}
+
+// microversionAtLeast returns true if the microversion is equal to or
+// newer than the required microversion.
+func microversionAtLeast(version, required string) (bool, error) {
+	if version == "" {
+		return false, nil
+	}
+
+	major, minor, err := parseMicroversion(version)
+	if err != nil {
+		return false, err
+	}
+
+	reqMajor, reqMinor, err := parseMicroversion(required)
+	if err != nil {
+		return false, err
+	}
+
+	if major != reqMajor {
+		return major > reqMajor, nil
+	}
+
+	return minor >= reqMinor, nil
+}
+
+// parseMicroversion parses the microversion formatted as "X.Y".
+func parseMicroversion(version string) (int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid microversion: %s", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid microversion: %s", version)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid microversion: %s", version)
+	}
+
+	return major, minor, nil
+}
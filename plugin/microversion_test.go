@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateMicroversion(t *testing.T) {
+	var tests = []struct {
+		body    string
+		version string
+		result  bool
+	}{
+		{`{"version": {"id": "v2.1", "version": "2.79", "min_version": "2.1"}}`, "2.26", true},
+		{`{"version": {"id": "v2.1", "version": "2.26", "min_version": "2.1"}}`, "2.26", true},
+		{`{"version": {"id": "v2.1", "version": "2.12", "min_version": "2.1"}}`, "2.12", true},
+		{`{"version": {"id": "v2.0", "version": "", "min_version": ""}}`, "", true},
+		{`{"version": {"id": "v2.1", "version": "invalid", "min_version": "2.1"}}`, "", false},
+	}
+
+	for _, test := range tests {
+		body := test.body
+		mux := http.NewServeMux()
+		mux.HandleFunc("/compute/", func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, body)
+		})
+
+		client, server := newTestClient(t, mux)
+
+		version, err := negotiateMicroversion(client.Compute, computeMicroversion)
+		if (err == nil) != test.result || version != test.version {
+			t.Errorf("unexpected result: %v - %s %v", test, version, err)
+		}
+
+		server.Close()
+	}
+}
+
+func TestMicroversionAtLeast(t *testing.T) {
+	var tests = []struct {
+		version  string
+		required string
+		ok       bool
+		result   bool
+	}{
+		{"2.26", "2.26", true, true},
+		{"2.79", "2.26", true, true},
+		{"3.1", "2.26", true, true},
+		{"2.9", "2.26", false, true},
+		{"", "2.26", false, true},
+		{"invalid", "2.26", false, false},
+	}
+
+	for _, test := range tests {
+		ok, err := microversionAtLeast(test.version, test.required)
+		if (err == nil) != test.result || ok != test.ok {
+			t.Errorf("unexpected result: %v - %v %v", test, ok, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+const challengeSynopsis = "Issues a challenge encrypted to the keypair of OpenStack instance."
+const challengeDescription = `
+Issues a nonce encrypted to the public key of the keypair that the instance
+was launched with. The instance decrypts the nonce with the private key and
+presents it on login as proof of the private key possession.
+`
+
+// challengeTTL is the lifetime of the challenge.
+const challengeTTL = 5 * time.Minute
+
+var challengeFields map[string]*framework.FieldSchema = map[string]*framework.FieldSchema{
+	"instance_id": {
+		Type:        framework.TypeString,
+		Description: "ID of the instance.",
+	},
+}
+
+func NewPathChallenge(b *OpenStackAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "login/challenge$",
+			Fields:  challengeFields,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.challengeHandler,
+			},
+			HelpSynopsis:    challengeSynopsis,
+			HelpDescription: challengeDescription,
+		},
+	}
+}
+
+func (b *OpenStackAuthBackend) challengeHandler(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	instanceID := data.Get("instance_id").(string)
+	if instanceID == "" {
+		return logical.ErrorResponse("instance_id required"), nil
+	}
+
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("backend is not configured"), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		msg := "openstack client error"
+		b.Logger().Error(msg, "error", err)
+		return nil, fmt.Errorf("%s: %v", msg, err)
+	}
+
+	instance, err := getInstance(client.Compute, instanceID)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to find instance: %v", err)), nil
+	}
+
+	keypair, err := getInstanceKeyPair(client.Compute, instance)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to get keypair: %v", err)), nil
+	}
+
+	value, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := encryptChallenge(keypair.PublicKey, value)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to encrypt challenge: %v", err)), nil
+	}
+
+	// The challenge is stored with the name derived from the nonce, so that
+	// a new challenge does not replace the challenges issued before.
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	err = updateSecret(ctx, req.Storage, SecretKindChallenge, &Secret{
+		Name:     secretName(SecretKindChallenge, instance.ID, value),
+		Hash:     hashSecret(value),
+		Deadline: time.Now().Add(challengeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &logical.Response{
+		Data: map[string]interface{}{
+			"challenge": challenge,
+		},
+	}
+
+	return res, nil
+}
+
+// encryptChallenge encrypts the value with RSA-OAEP and SHA-256 to the
+// public key in the OpenSSH authorized keys format. The result is encoded
+// in base64. Only RSA keys are supported since the other key types cannot
+// be used for encryption.
+func encryptChallenge(publicKey string, value string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", err
+	}
+
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return "", errors.New("unsupported public key")
+	}
+
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("unsupported public key type: %s", key.Type())
+	}
+
+	encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
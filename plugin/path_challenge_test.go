@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return key, string(ssh.MarshalAuthorizedKey(pub))
+}
+
+func decryptTestChallenge(t *testing.T, key *rsa.PrivateKey, challenge string) string {
+	encrypted, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, encrypted, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return string(value)
+}
+
+func TestEncryptChallenge(t *testing.T) {
+	key, publicKey := newTestKey(t)
+
+	challenge, err := encryptChallenge(publicKey, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value := decryptTestChallenge(t, key, challenge); value != "test" {
+		t.Errorf("unexpected value: %s", value)
+	}
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	edPub, err := ssh.NewPublicKey(edKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = encryptChallenge(string(ssh.MarshalAuthorizedKey(edPub)), "test")
+	if err == nil {
+		t.Errorf("expected error with ed25519 key")
+	}
+
+	_, err = encryptChallenge("invalid", "test")
+	if err == nil {
+		t.Errorf("expected error with invalid key")
+	}
+}
+
+func TestChallengeHandler(t *testing.T) {
+	key, publicKey := newTestKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"server": {
+			"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			"user_id": "9349aff8be7545ac9d2f1d00999a23cd",
+			"key_name": "deploy",
+			"status": "ACTIVE",
+			"created": "2020-01-01T00:00:00Z",
+			"updated": "2020-01-01T00:00:00Z"
+		}}`)
+	})
+	mux.HandleFunc("/compute/os-keypairs/deploy", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"keypair": {"name": "deploy", "public_key": "`+strings.TrimSpace(publicKey)+`"}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+	client.Compute.Microversion = "2.26"
+
+	ctx := context.Background()
+	b, storage := newTestBackend(t)
+	b.(*OpenStackAuthBackend).client = client
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/challenge",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"instance_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+		},
+	}
+
+	res, err := b.HandleRequest(ctx, req)
+	if err != nil || res == nil || !res.IsError() {
+		t.Errorf("expected error without configuration: %v - %v", res, err)
+	}
+
+	entry, err := logical.StorageEntryJSON("config", &Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/challenge",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"instance_id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+		},
+	}
+
+	// A new challenge must not replace the challenge issued before.
+	values := []string{}
+	for i := 0; i < 2; i++ {
+		res, err = b.HandleRequest(ctx, req)
+		if err != nil || res == nil || res.IsError() {
+			t.Fatalf("unexpected result: %v - %v", res, err)
+		}
+
+		values = append(values, decryptTestChallenge(t, key, res.Data["challenge"].(string)))
+	}
+
+	attestor := NewAttestor(storage, client)
+	instance := newTestInstance()
+
+	err = attestor.VerifySecrets(instance, map[string]string{SecretKindChallenge: "invalid"})
+	if err == nil {
+		t.Errorf("expected error with invalid response")
+	}
+
+	for _, value := range values {
+		err = attestor.VerifySecrets(instance, map[string]string{SecretKindChallenge: value})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	err = attestor.VerifySecrets(instance, map[string]string{SecretKindChallenge: values[0]})
+	if err == nil {
+		t.Errorf("expected error with used response")
+	}
+
+	// The challenge of the other instance cannot be used.
+	res, err = b.HandleRequest(ctx, req)
+	if err != nil || res == nil || res.IsError() {
+		t.Fatalf("unexpected result: %v - %v", res, err)
+	}
+
+	other := newTestInstance()
+	other.ID = "2ce4d8fb-2c5c-4b8e-a3b3-7c0e7a2d2f4b"
+
+	err = attestor.VerifySecrets(other, map[string]string{SecretKindChallenge: decryptTestChallenge(t, key, res.Data["challenge"].(string))})
+	if err == nil {
+		t.Errorf("expected error with challenge of other instance")
+	}
+}
@@ -2,6 +2,9 @@ package plugin
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -75,6 +78,31 @@ var configFields map[string]*framework.FieldSchema = map[string]*framework.Field
 		Type:        framework.TypeString,
 		Description: "Name of a domain which can be used to identify the source domain of either a user or a project.",
 	},
+	"trusted_proxy_cidrs": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of CIDR blocks of the trusted proxies and NAT gateways. The source address of the request from these addresses is resolved according to the proxy_mode of the role.",
+	},
+	"default_metadata_key": {
+		Type:        framework.TypeString,
+		Description: "The key of the instance metadata that contains the role name. This is used to select the role when the role is omitted on login.",
+	},
+	"vendordata_user_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of the Keystone user IDs that can call the vendordata endpoint. This is the user of the Nova dynamic vendordata. If not set, the vendordata endpoint is disabled.",
+	},
+	"identity_document_public_keys": {
+		Type:        framework.TypeStringSlice,
+		Description: "List of the trusted public keys or certificates in PEM format to verify the signed identity document.",
+	},
+	"identity_document_jwks": {
+		Type:        framework.TypeString,
+		Description: "The JSON Web Key Set that contains the trusted public keys to verify the signed identity document.",
+	},
+	"identity_document_clock_skew": {
+		Type:        framework.TypeDurationSecond,
+		Default:     int(defaultIdentityDocumentClockSkew / time.Second),
+		Description: "The allowed clock skew of the timestamp of the signed identity document in seconds.",
+	},
 }
 
 func NewPathConfig(b *OpenStackAuthBackend) []*framework.Path {
@@ -105,19 +133,25 @@ func (b *OpenStackAuthBackend) readConfigHandler(ctx context.Context, req *logic
 
 	res := &logical.Response{
 		Data: map[string]interface{}{
-			"auth_url":            config.AuthURL,
-			"user_id":             config.UserID,
-			"username":            config.Username,
-			"project_id":          config.ProjectID,
-			"project_name":        config.ProjectName,
-			"tenant_id":           config.TenantID,
-			"tenant_name":         config.TenantName,
-			"user_domain_id":      config.UserDomainID,
-			"user_domain_name":    config.UserDomainName,
-			"project_domain_id":   config.ProjectDomainID,
-			"project_domain_name": config.ProjectDomainName,
-			"domain_id":           config.DomainID,
-			"domain_name":         config.DomainName,
+			"auth_url":                      config.AuthURL,
+			"user_id":                       config.UserID,
+			"username":                      config.Username,
+			"project_id":                    config.ProjectID,
+			"project_name":                  config.ProjectName,
+			"tenant_id":                     config.TenantID,
+			"tenant_name":                   config.TenantName,
+			"user_domain_id":                config.UserDomainID,
+			"user_domain_name":              config.UserDomainName,
+			"project_domain_id":             config.ProjectDomainID,
+			"project_domain_name":           config.ProjectDomainName,
+			"domain_id":                     config.DomainID,
+			"domain_name":                   config.DomainName,
+			"trusted_proxy_cidrs":           config.TrustedProxyCIDRs,
+			"default_metadata_key":          config.DefaultMetadataKey,
+			"vendordata_user_ids":           config.VendordataUserIDs,
+			"identity_document_public_keys": config.IdentityDocumentPublicKeys,
+			"identity_document_jwks":        config.IdentityDocumentJWKS,
+			"identity_document_clock_skew":  int64(config.IdentityDocumentClockSkew / time.Second),
 		},
 	}
 
@@ -212,6 +246,52 @@ func (b *OpenStackAuthBackend) updateConfigHandler(ctx context.Context, req *log
 		config.DomainName = val.(string)
 	}
 
+	val, ok = data.GetOk("trusted_proxy_cidrs")
+	if ok {
+		cidrs := val.([]string)
+		for _, cidr := range cidrs {
+			_, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid trusted_proxy_cidrs: %v", err)), nil
+			}
+		}
+		config.TrustedProxyCIDRs = cidrs
+	}
+
+	val, ok = data.GetOk("default_metadata_key")
+	if ok {
+		config.DefaultMetadataKey = val.(string)
+	}
+
+	val, ok = data.GetOk("vendordata_user_ids")
+	if ok {
+		config.VendordataUserIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("identity_document_public_keys")
+	if ok {
+		config.IdentityDocumentPublicKeys = val.([]string)
+	}
+
+	val, ok = data.GetOk("identity_document_jwks")
+	if ok {
+		config.IdentityDocumentJWKS = val.(string)
+	}
+
+	_, err = parseIdentityDocumentKeys(config.IdentityDocumentPublicKeys, config.IdentityDocumentJWKS)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid identity document keys: %v", err)), nil
+	}
+
+	val, ok = data.GetOk("identity_document_clock_skew")
+	if ok {
+		skew := time.Duration(val.(int)) * time.Second
+		if skew < 0 {
+			return logical.ErrorResponse("identity_document_clock_skew cannot be negative"), nil
+		}
+		config.IdentityDocumentClockSkew = skew
+	}
+
 	entry, err := logical.StorageEntryJSON("config", config)
 	if err != nil {
 		return nil, err
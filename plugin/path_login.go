@@ -2,7 +2,10 @@ package plugin
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -22,7 +25,27 @@ var loginFields map[string]*framework.FieldSchema = map[string]*framework.FieldS
 	},
 	"role": {
 		Type:        framework.TypeString,
-		Description: "Name of the role.",
+		Description: "Name of the role. If omitted, the role name is read from the metadata of the instance with default_metadata_key of the configuration.",
+	},
+	"secret": {
+		Type:        framework.TypeString,
+		Description: "The single-use secret issued by the vendordata endpoint.",
+	},
+	"identity_document": {
+		Type:        framework.TypeString,
+		Description: "The identity document of the instance signed by a trusted signing service in JWS format. If specified, instance_id can be omitted.",
+	},
+	"push_secret": {
+		Type:        framework.TypeString,
+		Description: "The single-use secret pushed into the metadata of the instance by the backend.",
+	},
+	"user_data_token": {
+		Type:        framework.TypeString,
+		Description: "The token whose SHA-256 hash is embedded in the user data of the instance.",
+	},
+	"challenge_response": {
+		Type:        framework.TypeString,
+		Description: "The nonce issued by the login/challenge endpoint and decrypted with the private key of the keypair of the instance.",
 	},
 }
 
@@ -42,26 +65,32 @@ func NewPathLogin(b *OpenStackAuthBackend) []*framework.Path {
 }
 
 func (b *OpenStackAuthBackend) loginHandler(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	var val interface{}
-	var ok bool
+	instanceID := data.Get("instance_id").(string)
 
-	val, ok = data.GetOk("instance_id")
-	if !ok {
-		return logical.ErrorResponse("instance_id required"), nil
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
 	}
-	instanceID := val.(string)
 
-	val, ok = data.GetOk("role")
-	if !ok {
-		return logical.ErrorResponse("role required"), nil
-	}
-	roleName := val.(string)
+	// The instance ID is taken from the identity document if it is
+	// specified.
+	var doc *IdentityDocument
+	signed := data.Get("identity_document").(string)
+	if signed != "" {
+		doc, err = b.verifyIdentityDocument(config, signed)
+		if err != nil {
+			b.Logger().Info("identity document verification failed", "error", err)
+			return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+		}
 
-	b.Logger().Info("login attempt", "instance_id", instanceID, "role", roleName)
+		if instanceID != "" && instanceID != doc.InstanceID {
+			return logical.ErrorResponse("failed to login: identity document instance ID mismatched"), nil
+		}
+		instanceID = doc.InstanceID
+	}
 
-	role, err := readRole(ctx, req.Storage, roleName)
-	if err != nil || role == nil {
-		return logical.ErrorResponse(fmt.Sprintf("invalid role: %v", err)), nil
+	if instanceID == "" {
+		return logical.ErrorResponse("instance_id required"), nil
 	}
 
 	client, err := b.getClient(ctx, req.Storage)
@@ -71,24 +100,116 @@ func (b *OpenStackAuthBackend) loginHandler(ctx context.Context, req *logical.Re
 		return nil, fmt.Errorf("%s: %v", msg, err)
 	}
 
-	instance, err := servers.Get(client, instanceID).Extract()
+	instance, err := getInstance(client.Compute, instanceID)
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to find instance: %v", err)), nil
 	}
 
-	attestor := NewAttestor(req.Storage)
+	roleName := data.Get("role").(string)
+	if roleName == "" {
+		roleName, err = b.selectRole(ctx, req, instance)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to select role: %v", err)), nil
+		}
+	}
+
+	b.Logger().Info("login attempt", "instance_id", instanceID, "role", roleName)
+
+	role, err := readRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid role: %v", err)), nil
+	}
+
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role '%s' not found", roleName)), nil
+	}
+
+	if doc != nil && doc.ProjectID != instance.TenantID {
+		return logical.ErrorResponse("failed to login: identity document project ID mismatched"), nil
+	}
+
+	if role.RequireIdentityDocument && doc == nil {
+		return logical.ErrorResponse("failed to login: identity document required"), nil
+	}
+
+	if role.usesServerTags() {
+		instance.Tags, err = getInstanceTags(client.Compute, instanceID)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to get server tags: %v", err)), nil
+		}
+	}
+
+	attestor := NewAttestor(req.Storage, client)
 	if err != nil {
 		msg := "attestor error"
 		b.Logger().Error(msg, "error", err)
 		return nil, fmt.Errorf("%s: %v", msg, err)
 	}
 
-	err = attestor.Attest(instance, role, req.Connection.RemoteAddr)
+	addr, verifyAddr, err := b.resolveSourceAddr(ctx, req, role)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+	}
+
+	err = attestor.Attest(instance, role, addr, verifyAddr)
 	if err != nil {
 		b.Logger().Info("attestation failed", "error", err)
 		return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
 	}
 
+	if role.UserDataMarker != "" {
+		err = attestor.AttestUserDataToken(instance, role.UserDataMarker, data.Get("user_data_token").(string))
+		if err != nil {
+			b.Logger().Info("user data token verification failed", "error", err)
+			return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+		}
+	}
+
+	// The single-use secrets are consumed only on the actual login, not on
+	// the alias lookahead.
+	lookahead := req.Operation == logical.AliasLookaheadOperation
+
+	if !lookahead && doc != nil {
+		err = attestor.VerifyIdentityNonce(doc, config.IdentityDocumentClockSkew)
+		if err != nil {
+			b.Logger().Info("identity document nonce verification failed", "error", err)
+			return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+		}
+	}
+
+	secrets := map[string]string{}
+
+	secret := data.Get("secret").(string)
+	if role.RequireVendordataSecret || secret != "" {
+		secrets[SecretKindVendordata] = secret
+	}
+
+	if role.PushSecret {
+		secrets[SecretKindPush] = data.Get("push_secret").(string)
+	}
+
+	response := data.Get("challenge_response").(string)
+	if role.RequireChallenge || response != "" {
+		secrets[SecretKindChallenge] = response
+	}
+
+	if !lookahead && len(secrets) > 0 {
+		err = attestor.VerifySecrets(instance, secrets)
+		if err != nil {
+			b.Logger().Info("secret verification failed", "error", err)
+			return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+		}
+	}
+
+	// The pushed secret is deleted from the metadata only after all of the
+	// secrets have been verified.
+	if !lookahead && role.PushSecret {
+		err = servers.DeleteMetadatum(client.Compute, instance.ID, role.pushSecretMetadataKey()).ExtractErr()
+		if err != nil {
+			b.Logger().Warn("failed to delete pushed secret from metadata", "instance_id", instance.ID, "error", err)
+		}
+	}
+
 	res := &logical.Response{}
 
 	if req.Operation == logical.AliasLookaheadOperation {
@@ -108,6 +229,9 @@ func (b *OpenStackAuthBackend) loginHandler(ctx context.Context, req *logical.Re
 		Metadata: map[string]string{
 			"role": roleName,
 		},
+		InternalData: map[string]interface{}{
+			"source_addr": addr,
+		},
 		DisplayName: instance.Name,
 		LeaseOptions: logical.LeaseOptions{
 			Renewable: true,
@@ -116,6 +240,15 @@ func (b *OpenStackAuthBackend) loginHandler(ctx context.Context, req *logical.Re
 		},
 	}
 
+	if role.MaxInstanceAge > 0 {
+		remaining, err := attestor.VerifyMaxInstanceAge(instance, role.MaxInstanceAge)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to login: %v", err)), nil
+		}
+
+		b.capLeaseByAge(res.Auth, remaining)
+	}
+
 	return res, nil
 }
 
@@ -154,24 +287,70 @@ func (b *OpenStackAuthBackend) authRenewHandler(ctx context.Context, req *logica
 		return nil, fmt.Errorf("%s: %v", msg, err)
 	}
 
-	instance, err := servers.Get(client, instanceID).Extract()
+	instance, err := getInstance(client.Compute, instanceID)
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to find instance: %v", err)), nil
 	}
 
-	attestor := NewAttestor(req.Storage)
+	if role.usesServerTags() {
+		instance.Tags, err = getInstanceTags(client.Compute, instanceID)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to get server tags: %v", err)), nil
+		}
+	}
+
+	attestor := NewAttestor(req.Storage, client)
 	if err != nil {
 		msg := "attestor error"
 		b.Logger().Error(msg, "error", err)
 		return nil, fmt.Errorf("%s: %v", msg, err)
 	}
 
-	err = attestor.AttestMetadata(instance, role.MetadataKey, role.Name)
+	err = attestor.AttestRoleName(instance, role)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+	}
+
+	err = attestor.AttestServerTags(instance, role.BoundServerTags, role.BoundServerTagsMode)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+	}
+
+	err = attestor.AttestBoundMetadata(instance, role.BoundMetadata)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+	}
+
+	err = attestor.AttestKeyPair(instance, role.BoundKeyNames, role.BoundKeyFingerprints)
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
 	}
 
-	err = attestor.AttestAddr(instance, req.Connection.RemoteAddr)
+	err = attestor.AttestInstanceActions(instance, role.DeniedInstanceActions, role.InstanceActionCutoff)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+	}
+
+	addr, verifyAddr, err := b.resolveSourceAddr(ctx, req, role)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+	}
+
+	if verifyAddr {
+		err = attestor.AttestRoleAddr(instance, role, addr)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+		}
+
+		err = attestor.AttestNetwork(instance, addr, role.BoundNetworkIDs, role.BoundSubnetCIDRs)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
+		}
+	} else if role.usesNetworkBindings() {
+		return logical.ErrorResponse("failed to renew: network bindings require the source address validation"), nil
+	}
+
+	remaining, err := attestor.VerifyMaxInstanceAge(instance, role.MaxInstanceAge)
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to renew: %v", err)), nil
 	}
@@ -181,5 +360,187 @@ func (b *OpenStackAuthBackend) authRenewHandler(ctx context.Context, req *logica
 	res.Auth.TTL = role.TTL
 	res.Auth.MaxTTL = role.MaxTTL
 
+	if role.MaxInstanceAge > 0 {
+		b.capLeaseByAge(res.Auth, remaining)
+	}
+
 	return res, nil
 }
+
+// capLeaseByAge shortens the TTL, the max TTL and the period of the token
+// so that the token cannot outlive the remaining age of the instance.
+func (b *OpenStackAuthBackend) capLeaseByAge(auth *logical.Auth, remaining time.Duration) {
+	ttl := auth.TTL
+	if ttl == 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+	if ttl > remaining {
+		auth.TTL = remaining
+	}
+
+	maxTTL := auth.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = b.System().MaxLeaseTTL()
+	}
+	if maxTTL > remaining {
+		auth.MaxTTL = remaining
+	}
+
+	if auth.Period > remaining {
+		auth.Period = remaining
+	}
+}
+
+// verifyIdentityDocument verifies the signed identity document with the
+// trusted keys of the configuration.
+func (b *OpenStackAuthBackend) verifyIdentityDocument(config *Config, signed string) (*IdentityDocument, error) {
+	if config == nil {
+		return nil, errors.New("identity document is not configured")
+	}
+
+	keys, err := parseIdentityDocumentKeys(config.IdentityDocumentPublicKeys, config.IdentityDocumentJWKS)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyIdentityDocument(signed, keys, config.IdentityDocumentClockSkew)
+}
+
+// selectRole returns the role name read from the metadata of the instance
+// with the default metadata key of the configuration. This is used when
+// the role is omitted on login.
+func (b *OpenStackAuthBackend) selectRole(ctx context.Context, req *logical.Request, instance *Instance) (string, error) {
+	roleNames, err := b.metadataRoleNames(ctx, req, instance)
+	if err != nil {
+		return "", err
+	}
+
+	if len(roleNames) == 0 {
+		return "", errors.New("no role found in metadata")
+	}
+
+	if len(roleNames) > 1 {
+		return "", errors.New("metadata contains multiple roles: role must be specified")
+	}
+
+	return roleNames[0], nil
+}
+
+// metadataRoleNames returns the role names read from the metadata of the
+// instance with the default metadata key of the configuration.
+func (b *OpenStackAuthBackend) metadataRoleNames(ctx context.Context, req *logical.Request, instance *Instance) ([]string, error) {
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil || config.DefaultMetadataKey == "" {
+		return nil, errors.New("default_metadata_key is not configured")
+	}
+
+	val, ok := instance.Metadata[config.DefaultMetadataKey]
+	if !ok || val == "" {
+		return nil, fmt.Errorf("metadata key '%s' not found", config.DefaultMetadataKey)
+	}
+
+	// Role names cannot contain commas or brackets, so the format of the
+	// value can be detected without the role configuration.
+	format := MetadataFormatCSV
+	if strings.HasPrefix(strings.TrimSpace(val), "[") {
+		format = MetadataFormatJSON
+	}
+
+	return parseMetadataRoles(val, format)
+}
+
+// resolveSourceAddr returns the source address of the request and whether
+// the address should be validated. If the request comes from a trusted
+// proxy, the source address is resolved according to the proxy mode of
+// the role. Note that PROXY protocol is handled by the Vault listener and
+// the connection address already contains the original source address.
+func (b *OpenStackAuthBackend) resolveSourceAddr(ctx context.Context, req *logical.Request, role *Role) (string, bool, error) {
+	if req.Connection == nil || req.Connection.RemoteAddr == "" {
+		return "", false, errors.New("source address not found")
+	}
+	addr := req.Connection.RemoteAddr
+
+	if role.ProxyMode == "" || role.ProxyMode == ProxyModeNone {
+		return addr, true, nil
+	}
+
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return "", false, err
+	}
+
+	if config == nil || !isTrustedProxy(config.TrustedProxyCIDRs, addr) {
+		return addr, true, nil
+	}
+
+	switch role.ProxyMode {
+	case ProxyModeSkip:
+		return addr, false, nil
+	case ProxyModeForwarded:
+		// The renewal request does not carry the passthrough request
+		// headers, so the client address resolved on login is used.
+		if req.Operation == logical.RenewOperation && req.Auth != nil {
+			recorded, _ := req.Auth.InternalData["source_addr"].(string)
+			if recorded == "" {
+				return "", false, errors.New("source address of the login not found")
+			}
+			return recorded, true, nil
+		}
+
+		forwarded := forwardedAddr(req.Headers["X-Forwarded-For"], config.TrustedProxyCIDRs)
+		if forwarded == "" {
+			return "", false, errors.New("X-Forwarded-For header not found")
+		}
+		return forwarded, true, nil
+	}
+
+	return "", false, fmt.Errorf("invalid proxy mode: %s", role.ProxyMode)
+}
+
+// isTrustedProxy returns true if the address is contained in the CIDR
+// blocks of the trusted proxies.
+func isTrustedProxy(cidrs []string, addr string) bool {
+	ip := parseAddr(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		if containsAddr(cidr, ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedAddr returns the client address from the values of
+// X-Forwarded-For header. The addresses are evaluated from right to left
+// and the first address that is not a trusted proxy is returned.
+func forwardedAddr(values []string, cidrs []string) string {
+	addrs := []string{}
+	for _, val := range values {
+		for _, addr := range strings.Split(val, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return ""
+	}
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !isTrustedProxy(cidrs, addrs[i]) {
+			return addrs[i]
+		}
+	}
+
+	return addrs[0]
+}
@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestResolveSourceAddr(t *testing.T) {
+	var tests = []struct {
+		mode       string
+		remoteAddr string
+		forwarded  []string
+		addr       string
+		verifyAddr bool
+		result     bool
+	}{
+		{"", "192.168.1.1", nil, "192.168.1.1", true, true},
+		{"none", "10.0.0.1", []string{"192.168.1.1"}, "10.0.0.1", true, true},
+		{"forwarded", "10.0.0.1", []string{"192.168.1.1"}, "192.168.1.1", true, true},
+		{"forwarded", "10.0.0.1", []string{"192.168.1.1, 10.0.0.2"}, "192.168.1.1", true, true},
+		{"forwarded", "10.0.0.1", []string{"203.0.113.1, 192.168.1.1"}, "192.168.1.1", true, true},
+		{"forwarded", "10.0.0.1", []string{"203.0.113.1", "192.168.1.1"}, "192.168.1.1", true, true},
+		{"forwarded", "10.0.0.1", nil, "", false, false},
+		{"forwarded", "192.168.1.1", []string{"203.0.113.1"}, "192.168.1.1", true, true},
+		{"skip", "10.0.0.1", nil, "10.0.0.1", false, true},
+		{"skip", "192.168.1.1", nil, "192.168.1.1", true, true},
+		{"", "", nil, "", false, false},
+	}
+
+	b, storage := newTestBackend(t)
+	backend := b.(*OpenStackAuthBackend)
+
+	config := &Config{TrustedProxyCIDRs: []string{"10.0.0.0/24"}}
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Put(context.Background(), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		req := &logical.Request{
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: test.remoteAddr},
+			Headers:    map[string][]string{},
+		}
+		if test.forwarded != nil {
+			req.Headers["X-Forwarded-For"] = test.forwarded
+		}
+		role := &Role{Name: "test", ProxyMode: test.mode}
+
+		addr, verifyAddr, err := backend.resolveSourceAddr(context.Background(), req, role)
+		if (err == nil) != test.result || addr != test.addr || verifyAddr != test.verifyAddr {
+			t.Errorf("unexpected result: %v - %s %v %v", test, addr, verifyAddr, err)
+		}
+	}
+}
+
+func TestAuthRenewForwarded(t *testing.T) {
+	var tests = []struct {
+		recorded string
+		result   bool
+	}{
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"", false},
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, fmt.Sprintf(`{"server": {
+			"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			"status": "ACTIVE",
+			"addresses": {"private": [{"addr": "192.168.1.1", "version": 4}]},
+			"metadata": {"vault-role": "test"},
+			"created": "%s",
+			"updated": "%s"
+		}}`, created, created))
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	b, storage := newTestBackend(t)
+	b.(*OpenStackAuthBackend).client = client
+
+	entry, err := logical.StorageEntryJSON("config", &Config{TrustedProxyCIDRs: []string{"10.0.0.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role := &Role{
+		Name:        "test",
+		Policies:    []string{"default"},
+		MetadataKey: "vault-role",
+		ProxyMode:   ProxyModeForwarded,
+	}
+	entry, err = logical.StorageEntryJSON("role/test", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		// The renewal request comes from the trusted proxy without the
+		// X-Forwarded-For header.
+		req := &logical.Request{
+			Operation:  logical.RenewOperation,
+			Path:       "login",
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: "10.0.0.1"},
+			Auth: &logical.Auth{
+				Alias:    &logical.Alias{Name: "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5"},
+				Policies: []string{"default"},
+				Metadata: map[string]string{"role": "test"},
+				InternalData: map[string]interface{}{
+					"source_addr": test.recorded,
+				},
+			},
+		}
+
+		res, err := b.HandleRequest(ctx, req)
+		ok := err == nil && res != nil && !res.IsError()
+		if ok != test.result {
+			t.Errorf("unexpected result: %v - %v, %v", test, res, err)
+		}
+	}
+}
+
+func TestCapLeaseByAge(t *testing.T) {
+	var tests = []struct {
+		ttl       time.Duration
+		maxTTL    time.Duration
+		period    time.Duration
+		remaining time.Duration
+		expTTL    time.Duration
+		expMaxTTL time.Duration
+		expPeriod time.Duration
+	}{
+		{time.Hour, 2 * time.Hour, 0, 3 * time.Hour, time.Hour, 2 * time.Hour, 0},
+		{time.Hour, 0, 0, 2 * time.Hour, time.Hour, 2 * time.Hour, 0},
+		{time.Hour, 2 * time.Hour, 0, 30 * time.Minute, 30 * time.Minute, 30 * time.Minute, 0},
+		{0, 0, 0, 30 * time.Minute, 30 * time.Minute, 30 * time.Minute, 0},
+		{0, 0, time.Hour, 30 * time.Minute, 30 * time.Minute, 30 * time.Minute, 30 * time.Minute},
+	}
+
+	b, _ := newTestBackend(t)
+
+	for _, test := range tests {
+		auth := &logical.Auth{
+			Period: test.period,
+			LeaseOptions: logical.LeaseOptions{
+				TTL:    test.ttl,
+				MaxTTL: test.maxTTL,
+			},
+		}
+
+		b.(*OpenStackAuthBackend).capLeaseByAge(auth, test.remaining)
+		if auth.TTL != test.expTTL || auth.MaxTTL != test.expMaxTTL || auth.Period != test.expPeriod {
+			t.Errorf("unexpected result: %v - %s, %s, %s", test, auth.TTL, auth.MaxTTL, auth.Period)
+		}
+	}
+}
+
+func TestSelectRole(t *testing.T) {
+	var tests = []struct {
+		metadataKey string
+		metadata    map[string]string
+		role        string
+		result      bool
+	}{
+		{"vault-role", map[string]string{"vault-role": "test"}, "test", true},
+		{"", map[string]string{"vault-role": "test"}, "", false},
+		{"vault-role", map[string]string{}, "", false},
+		{"vault-role", map[string]string{"vault-role": ""}, "", false},
+		{"vault-role", map[string]string{"vault-role": `["test"]`}, "test", true},
+		{"vault-role", map[string]string{"vault-role": "dev,test"}, "", false},
+		{"vault-role", map[string]string{"vault-role": `["dev", "test"]`}, "", false},
+		{"vault-role", map[string]string{"vault-role": "[]"}, "", false},
+		{"vault-role", map[string]string{"vault-role": ","}, "", false},
+		{"vault-role", map[string]string{"vault-role": "Test"}, "test", true},
+		{"vault-role", map[string]string{"vault-role": `["TEST"]`}, "test", true},
+	}
+
+	ctx := context.Background()
+	b, storage := newTestBackend(t)
+
+	for _, test := range tests {
+		entry, err := logical.StorageEntryJSON("config", &Config{DefaultMetadataKey: test.metadataKey})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = storage.Put(ctx, entry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		instance := newTestInstance()
+		instance.Metadata = test.metadata
+
+		req := &logical.Request{Storage: storage}
+		role, err := b.(*OpenStackAuthBackend).selectRole(ctx, req, instance)
+		if (err == nil) != test.result || role != test.role {
+			t.Errorf("unexpected result: %v - %s, %v", test, role, err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -52,16 +53,188 @@ var roleFields map[string]*framework.FieldSchema = map[string]*framework.FieldSc
 		Default:     "vault-role",
 		Description: "The key name of the instance metadata to validate the role specified during authentication. The role name must be specified for the key of metadata of the instance specified here.",
 	},
+	"metadata_format": {
+		Type:        framework.TypeString,
+		Default:     MetadataFormatString,
+		Description: "The format of the metadata value specified by metadata_key. If 'string', the value is a single role name. If 'csv', the value is comma separated role names. If 'json', the value is a JSON array of role names.",
+	},
+	"role_tag_prefix": {
+		Type:        framework.TypeString,
+		Description: "The prefix of the server tag to validate the role specified during authentication. If set, the instance must have the server tag that consists of the prefix and the role name instead of the metadata specified by metadata_key. This requires the compute API microversion 2.26 or later.",
+	},
+	"bound_instance_name_patterns": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of patterns of the instance name. If set, only the instances whose name and hostname match one of the patterns can authenticate.",
+	},
+	"instance_name_pattern_type": {
+		Type:        framework.TypeString,
+		Default:     PatternTypeGlob,
+		Description: "The type of bound_instance_name_patterns. If 'glob', the patterns are glob patterns. If 'regex', the patterns are regular expressions.",
+	},
+	"bound_metadata": {
+		Type:        framework.TypeMap,
+		Description: "Map of the instance metadata keys and the allowed values. The value is a list or a comma separated string of the allowed values, which support glob patterns. If set, only the instances that have all of the keys with one of the allowed values can authenticate.",
+	},
+	"bound_project_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of project IDs. If set, only the instances that belong to one of the projects can authenticate.",
+	},
+	"bound_user_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of user IDs. If set, only the instances that are created by one of the users can authenticate.",
+	},
+	"bound_parent_project_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of Keystone project IDs. If set, only the instances that belong to a descendant project of one of the projects can authenticate.",
+	},
+	"bound_project_tags": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of Keystone project tags. If set, only the instances that belong to the project that has one of the tags can authenticate.",
+	},
+	"bound_domain_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of Keystone domain IDs. If set, only the instances that belong to the project in one of the domains can authenticate.",
+	},
+	"bound_image_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of image IDs. If set, only the instances that are booted from one of the images can authenticate.",
+	},
+	"bound_image_properties": {
+		Type:        framework.TypeKVPairs,
+		Description: "Key/value pairs of the image properties. If set, only the instances that are booted from the image that has all of the properties can authenticate. The value supports glob patterns.",
+	},
+	"bound_flavor_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of flavor IDs. If set, only the instances that have one of the flavors can authenticate.",
+	},
+	"bound_flavor_names": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of flavor names. If set, only the instances that have one of the flavors can authenticate.",
+	},
+	"bound_availability_zones": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of availability zones. If set, only the instances that run in one of the availability zones can authenticate.",
+	},
+	"bound_host_aggregates": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of host aggregate IDs or names. If set, only the instances that run on a host in one of the host aggregates can authenticate.",
+	},
+	"bound_key_names": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of keypair names. If set, only the instances launched with one of the keypairs can authenticate.",
+	},
+	"bound_key_fingerprints": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of keypair fingerprints. If set, only the instances launched with the keypair that has one of the fingerprints can authenticate.",
+	},
+	"bound_server_group_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of server group IDs. If set, only the instances that are members of one of the server groups can authenticate.",
+	},
+	"bound_server_tags": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of server tags. If set, only the instances that have the server tags can authenticate. This requires the compute API microversion 2.26 or later.",
+	},
+	"bound_server_tags_mode": {
+		Type:        framework.TypeString,
+		Default:     TagsModeAll,
+		Description: "If 'all', the instance must have all of the bound_server_tags. If 'any', the instance must have any of the bound_server_tags.",
+	},
+	"bound_security_groups": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of security group names or IDs. If set, only the instances that belong to one of the security groups can authenticate. IDs are matched only if verify_port_security_groups is true, and names are matched only if bound_project_ids is set.",
+	},
+	"verify_port_security_groups": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "If true, bound_security_groups are verified with the security groups of the Neutron ports attached to the instance instead of the security groups reported by Nova.",
+	},
+	"bound_network_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of Neutron network IDs. If set, only the instances that authenticate from the port attached to one of the networks can authenticate.",
+	},
+	"bound_subnet_cidrs": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Comma separated list of CIDR blocks of the subnets. If set, only the instances that authenticate from the port whose fixed IP is contained in one of the CIDR blocks can authenticate.",
+	},
+	"allowed_statuses": {
+		Type:        framework.TypeCommaStringSlice,
+		Default:     defaultAllowedStatuses,
+		Description: "Comma separated list of the instance statuses that can authenticate. Locked, rescued and shelved instances and the instances in the middle of a task cannot authenticate regardless of this setting.",
+	},
 	"auth_period": {
 		Type:        framework.TypeDurationSecond,
 		Default:     120,
 		Description: "The authentication deadline. This is the relative number of seconds since the instance started.",
 	},
+	"auth_period_start": {
+		Type:        framework.TypeString,
+		Default:     AuthPeriodStartCreated,
+		Description: "The start of the authentication period. If 'created', the period starts at the creation time of the instance. If 'launched', the period starts at the launch time of the instance. If 'rebuilt', the period starts at the latest rebuild of the instance, or the creation time if the instance has never been rebuilt.",
+	},
+	"min_instance_age": {
+		Type:        framework.TypeDurationSecond,
+		Default:     0,
+		Description: "The minimum age of the instance. The age is the relative number of seconds since the start of the authentication period. The instances younger than this cannot authenticate.",
+	},
+	"max_instance_age": {
+		Type:        framework.TypeDurationSecond,
+		Default:     0,
+		Description: "The maximum age of the instance. The age is the relative number of seconds since the instance was created. The instances older than this cannot authenticate or renew the token, and the TTL of the token is capped by the remaining age.",
+	},
+	"denied_instance_actions": {
+		Type:        framework.TypeCommaStringSlice,
+		Default:     defaultDeniedInstanceActions,
+		Description: "Comma separated list of the instance actions that are denied after instance_action_cutoff. If the instance has any of the actions in its instance action log, the instance cannot authenticate.",
+	},
+	"instance_action_cutoff": {
+		Type:        framework.TypeString,
+		Description: "The cutoff time of the instance actions in RFC3339 format. If set, the instances that have any of denied_instance_actions after the cutoff cannot authenticate.",
+	},
 	"auth_limit": {
 		Type:        framework.TypeInt,
 		Default:     1,
 		Description: "The number of times an instance can try authentication.",
 	},
+	"address_source": {
+		Type:        framework.TypeString,
+		Default:     AddressSourceNova,
+		Description: "The source of the instance addresses used to validate the source IP address of the login request. If 'nova', the addresses reported by Nova are used. If 'neutron', the fixed IPs, allowed address pairs and floating IPs of the Neutron ports attached to the instance are used.",
+	},
+	"proxy_mode": {
+		Type:        framework.TypeString,
+		Default:     ProxyModeNone,
+		Description: "How to handle the request from the trusted proxies configured in the backend. If 'none', the address of the connection is used as the source address. If 'forwarded', the source address is taken from X-Forwarded-For header. If 'skip', the source address validation is skipped.",
+	},
+	"require_vendordata_secret": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "If true, the instance must present the single-use secret issued by the vendordata endpoint on login.",
+	},
+	"require_challenge": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "If true, the instance must present the nonce issued by the login/challenge endpoint and decrypted with the private key of its keypair on login.",
+	},
+	"push_secret": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "If true, the backend pushes a single-use secret into the metadata of the new instances of the role, and the instance must present the secret on login.",
+	},
+	"push_secret_metadata_key": {
+		Type:        framework.TypeString,
+		Default:     defaultPushSecretMetadataKey,
+		Description: "The key of the instance metadata that the secret is pushed into. The key is deleted after login.",
+	},
+	"user_data_marker": {
+		Type:        framework.TypeString,
+		Description: "The marker of the token hash embedded in the user data of the instance. If set, the instance must present the token whose SHA-256 hash follows the marker in the user data on login. This requires the compute API microversion 2.3 or later.",
+	},
+	"require_identity_document": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "If true, the instance must present the identity document signed by a trusted signing service on login.",
+	},
 }
 
 func NewPathRole(b *OpenStackAuthBackend) []*framework.Path {
@@ -121,13 +294,52 @@ func (b *OpenStackAuthBackend) readRoleHandler(ctx context.Context, req *logical
 
 	res := &logical.Response{
 		Data: map[string]interface{}{
-			"policies":     role.Policies,
-			"ttl":          int64(role.TTL / time.Second),
-			"max_ttl":      int64(role.MaxTTL / time.Second),
-			"period":       int64(role.Period / time.Second),
-			"metadata_key": role.MetadataKey,
-			"auth_period":  int64(role.AuthPeriod / time.Second),
-			"auth_limit":   role.AuthLimit,
+			"policies":                     role.Policies,
+			"ttl":                          int64(role.TTL / time.Second),
+			"max_ttl":                      int64(role.MaxTTL / time.Second),
+			"period":                       int64(role.Period / time.Second),
+			"metadata_key":                 role.MetadataKey,
+			"metadata_format":              role.MetadataFormat,
+			"role_tag_prefix":              role.RoleTagPrefix,
+			"bound_instance_name_patterns": role.BoundInstanceNamePatterns,
+			"instance_name_pattern_type":   role.InstanceNamePatternType,
+			"bound_metadata":               role.BoundMetadata,
+			"bound_project_ids":            role.BoundProjectIDs,
+			"bound_user_ids":               role.BoundUserIDs,
+			"bound_parent_project_ids":     role.BoundParentProjectIDs,
+			"bound_project_tags":           role.BoundProjectTags,
+			"bound_domain_ids":             role.BoundDomainIDs,
+			"bound_image_ids":              role.BoundImageIDs,
+			"bound_image_properties":       role.BoundImageProperties,
+			"bound_flavor_ids":             role.BoundFlavorIDs,
+			"bound_flavor_names":           role.BoundFlavorNames,
+			"bound_availability_zones":     role.BoundAvailabilityZones,
+			"bound_host_aggregates":        role.BoundHostAggregates,
+			"bound_key_names":              role.BoundKeyNames,
+			"bound_key_fingerprints":       role.BoundKeyFingerprints,
+			"bound_server_group_ids":       role.BoundServerGroupIDs,
+			"bound_server_tags":            role.BoundServerTags,
+			"bound_server_tags_mode":       role.BoundServerTagsMode,
+			"bound_security_groups":        role.BoundSecurityGroups,
+			"verify_port_security_groups":  role.VerifyPortSecurityGroups,
+			"bound_network_ids":            role.BoundNetworkIDs,
+			"bound_subnet_cidrs":           role.BoundSubnetCIDRs,
+			"allowed_statuses":             role.AllowedStatuses,
+			"auth_period":                  int64(role.AuthPeriod / time.Second),
+			"auth_period_start":            role.AuthPeriodStart,
+			"min_instance_age":             int64(role.MinInstanceAge / time.Second),
+			"max_instance_age":             int64(role.MaxInstanceAge / time.Second),
+			"denied_instance_actions":      role.DeniedInstanceActions,
+			"instance_action_cutoff":       role.InstanceActionCutoff,
+			"auth_limit":                   role.AuthLimit,
+			"address_source":               role.AddressSource,
+			"proxy_mode":                   role.ProxyMode,
+			"require_vendordata_secret":    role.RequireVendordataSecret,
+			"require_challenge":            role.RequireChallenge,
+			"push_secret":                  role.PushSecret,
+			"push_secret_metadata_key":     role.PushSecretMetadataKey,
+			"user_data_marker":             role.UserDataMarker,
+			"require_identity_document":    role.RequireIdentityDocument,
 		},
 	}
 
@@ -177,16 +389,214 @@ func (b *OpenStackAuthBackend) updateRoleHandler(ctx context.Context, req *logic
 		role.MetadataKey = val.(string)
 	}
 
+	val, ok = data.GetOk("metadata_format")
+	if ok {
+		role.MetadataFormat = val.(string)
+	}
+
+	val, ok = data.GetOk("role_tag_prefix")
+	if ok {
+		role.RoleTagPrefix = val.(string)
+	}
+
+	val, ok = data.GetOk("bound_instance_name_patterns")
+	if ok {
+		role.BoundInstanceNamePatterns = val.([]string)
+	}
+
+	val, ok = data.GetOk("instance_name_pattern_type")
+	if ok {
+		role.InstanceNamePatternType = val.(string)
+	}
+
+	val, ok = data.GetOk("bound_metadata")
+	if ok {
+		role.BoundMetadata, err = parseBoundMap(val.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_metadata: %v", err)), nil
+		}
+	}
+
+	val, ok = data.GetOk("bound_project_ids")
+	if ok {
+		role.BoundProjectIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_user_ids")
+	if ok {
+		role.BoundUserIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_parent_project_ids")
+	if ok {
+		role.BoundParentProjectIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_project_tags")
+	if ok {
+		role.BoundProjectTags = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_domain_ids")
+	if ok {
+		role.BoundDomainIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_image_ids")
+	if ok {
+		role.BoundImageIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_image_properties")
+	if ok {
+		role.BoundImageProperties = val.(map[string]string)
+	}
+
+	val, ok = data.GetOk("bound_flavor_ids")
+	if ok {
+		role.BoundFlavorIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_flavor_names")
+	if ok {
+		role.BoundFlavorNames = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_availability_zones")
+	if ok {
+		role.BoundAvailabilityZones = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_host_aggregates")
+	if ok {
+		role.BoundHostAggregates = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_key_names")
+	if ok {
+		role.BoundKeyNames = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_key_fingerprints")
+	if ok {
+		role.BoundKeyFingerprints = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_server_group_ids")
+	if ok {
+		role.BoundServerGroupIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_server_tags")
+	if ok {
+		role.BoundServerTags = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_server_tags_mode")
+	if ok {
+		role.BoundServerTagsMode = val.(string)
+	}
+
+	val, ok = data.GetOk("bound_security_groups")
+	if ok {
+		role.BoundSecurityGroups = val.([]string)
+	}
+
+	val, ok = data.GetOk("verify_port_security_groups")
+	if ok {
+		role.VerifyPortSecurityGroups = val.(bool)
+	}
+
+	val, ok = data.GetOk("bound_network_ids")
+	if ok {
+		role.BoundNetworkIDs = val.([]string)
+	}
+
+	val, ok = data.GetOk("bound_subnet_cidrs")
+	if ok {
+		role.BoundSubnetCIDRs = val.([]string)
+	}
+
+	val, ok = data.GetOk("allowed_statuses")
+	if ok {
+		role.AllowedStatuses = val.([]string)
+	}
+
 	val, ok = data.GetOk("auth_period")
 	if ok {
 		role.AuthPeriod = time.Duration(val.(int)) * time.Second
 	}
 
+	val, ok = data.GetOk("auth_period_start")
+	if ok {
+		role.AuthPeriodStart = val.(string)
+	}
+
+	val, ok = data.GetOk("min_instance_age")
+	if ok {
+		role.MinInstanceAge = time.Duration(val.(int)) * time.Second
+	}
+
+	val, ok = data.GetOk("max_instance_age")
+	if ok {
+		role.MaxInstanceAge = time.Duration(val.(int)) * time.Second
+	}
+
+	val, ok = data.GetOk("denied_instance_actions")
+	if ok {
+		role.DeniedInstanceActions = val.([]string)
+	}
+
+	val, ok = data.GetOk("instance_action_cutoff")
+	if ok {
+		role.InstanceActionCutoff = val.(string)
+	}
+
 	val, ok = data.GetOk("auth_limit")
 	if ok {
 		role.AuthLimit = val.(int)
 	}
 
+	val, ok = data.GetOk("address_source")
+	if ok {
+		role.AddressSource = val.(string)
+	}
+
+	val, ok = data.GetOk("proxy_mode")
+	if ok {
+		role.ProxyMode = val.(string)
+	}
+
+	val, ok = data.GetOk("require_vendordata_secret")
+	if ok {
+		role.RequireVendordataSecret = val.(bool)
+	}
+
+	val, ok = data.GetOk("require_challenge")
+	if ok {
+		role.RequireChallenge = val.(bool)
+	}
+
+	val, ok = data.GetOk("push_secret")
+	if ok {
+		role.PushSecret = val.(bool)
+	}
+
+	val, ok = data.GetOk("push_secret_metadata_key")
+	if ok {
+		role.PushSecretMetadataKey = val.(string)
+	}
+
+	val, ok = data.GetOk("user_data_marker")
+	if ok {
+		role.UserDataMarker = val.(string)
+	}
+
+	val, ok = data.GetOk("require_identity_document")
+	if ok {
+		role.RequireIdentityDocument = val.(bool)
+	}
+
 	warnings, err := role.Validate(b.System())
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("invalid role: %v", err)), nil
@@ -231,3 +641,34 @@ func (b *OpenStackAuthBackend) listRoleHandler(ctx context.Context, req *logical
 
 	return logical.ListResponse(roles), nil
 }
+
+// parseBoundMap parses the map of the bindings. The value of the map must
+// be a list or a comma separated string.
+func parseBoundMap(m map[string]interface{}) (map[string][]string, error) {
+	bound := map[string][]string{}
+
+	for key, val := range m {
+		switch v := val.(type) {
+		case string:
+			bound[key] = strutil.ParseStringSlice(v, ",")
+		case []interface{}:
+			vals := []string{}
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid value of '%s'", key)
+				}
+				vals = append(vals, str)
+			}
+			bound[key] = vals
+		default:
+			return nil, fmt.Errorf("invalid value of '%s'", key)
+		}
+
+		if len(bound[key]) == 0 {
+			return nil, fmt.Errorf("no values of '%s'", key)
+		}
+	}
+
+	return bound, nil
+}
@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBoundMap(t *testing.T) {
+	var tests = []struct {
+		input  map[string]interface{}
+		bound  map[string][]string
+		result bool
+	}{
+		{map[string]interface{}{}, map[string][]string{}, true},
+		{map[string]interface{}{"env": "prod"}, map[string][]string{"env": {"prod"}}, true},
+		{map[string]interface{}{"env": "prod, staging"}, map[string][]string{"env": {"prod", "staging"}}, true},
+		{map[string]interface{}{"env": []interface{}{"prod", "staging"}}, map[string][]string{"env": {"prod", "staging"}}, true},
+		{map[string]interface{}{"env": ""}, nil, false},
+		{map[string]interface{}{"env": []interface{}{}}, nil, false},
+		{map[string]interface{}{"env": []interface{}{1}}, nil, false},
+		{map[string]interface{}{"env": 1}, nil, false},
+	}
+
+	for _, test := range tests {
+		bound, err := parseBoundMap(test.input)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test, err)
+			continue
+		}
+
+		if err == nil && !reflect.DeepEqual(bound, test.bound) {
+			t.Errorf("unexpected bindings: %v - %v", test, bound)
+		}
+	}
+}
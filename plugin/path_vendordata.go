@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const vendordataSynopsis = "Issues a single-use login secret to Nova dynamic vendordata."
+const vendordataDescription = `
+This endpoint is called by Nova dynamic vendordata (DynamicJSON) when the
+instance boots. It validates the Keystone token supplied by Nova, and
+returns a single-use secret bound to the instance. The instance can read
+the secret from the metadata service and present it on login.
+`
+
+var vendordataFields map[string]*framework.FieldSchema = map[string]*framework.FieldSchema{
+	"instance-id": {
+		Type:        framework.TypeString,
+		Description: "ID of the instance supplied by Nova.",
+	},
+	"project-id": {
+		Type:        framework.TypeString,
+		Description: "ID of the project of the instance supplied by Nova.",
+	},
+}
+
+func NewPathVendordata(b *OpenStackAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "vendordata$",
+			Fields:  vendordataFields,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.vendordataHandler,
+			},
+			HelpSynopsis:    vendordataSynopsis,
+			HelpDescription: vendordataDescription,
+		},
+	}
+}
+
+func (b *OpenStackAuthBackend) vendordataHandler(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	instanceID := data.Get("instance-id").(string)
+	if instanceID == "" {
+		return logical.ErrorResponse("instance-id required"), nil
+	}
+
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil || len(config.VendordataUserIDs) == 0 {
+		return logical.ErrorResponse("vendordata is not configured"), nil
+	}
+
+	client, err := b.getClient(ctx, req.Storage)
+	if err != nil {
+		msg := "openstack client error"
+		b.Logger().Error(msg, "error", err)
+		return nil, fmt.Errorf("%s: %v", msg, err)
+	}
+
+	err = verifyVendordataToken(client, req.Headers["X-Auth-Token"], config.VendordataUserIDs)
+	if err != nil {
+		b.Logger().Info("vendordata token verification failed", "instance_id", instanceID, "error", err)
+		return nil, logical.ErrPermissionDenied
+	}
+
+	instance, err := getInstance(client.Compute, instanceID)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to find instance: %v", err)), nil
+	}
+
+	projectID := data.Get("project-id").(string)
+	if projectID != "" && projectID != instance.TenantID {
+		return logical.ErrorResponse("project ID mismatched"), nil
+	}
+
+	deadline, err := b.vendordataDeadline(ctx, req, client, instance)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to issue secret: %v", err)), nil
+	}
+
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	secret, err := readSecret(ctx, req.Storage, SecretKindVendordata, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The secret is not issued again once it has been used, since anyone
+	// in the instance can read the metadata service.
+	if secret != nil && secret.Used {
+		return logical.ErrorResponse("secret has already been used"), nil
+	}
+
+	// Nova calls the endpoint on each cache miss of the metadata service,
+	// so the same secret is returned until it is used or expires.
+	value := ""
+	if secret != nil && secret.Deadline.Equal(deadline) {
+		value, err = readSecretCache(ctx, req.Storage, SecretKindVendordata, instance.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != "" && !secret.Verify(value) {
+			value = ""
+		}
+	}
+
+	if value == "" {
+		value, err = generateSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		err = updateSecret(ctx, req.Storage, SecretKindVendordata, &Secret{
+			Name:     instance.ID,
+			Hash:     hashSecret(value),
+			Deadline: deadline,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = updateSecretCache(ctx, req.Storage, SecretKindVendordata, instance.ID, value)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Logger().Info("vendordata secret issued", "instance_id", instance.ID)
+	}
+
+	res := &logical.Response{
+		Data: map[string]interface{}{
+			"secret": value,
+		},
+	}
+
+	return res, nil
+}
+
+// vendordataDeadline returns the deadline of the secret. The deadline is
+// the end of the longest authentication period of the roles bound to the
+// instance with the metadata or the server tags.
+func (b *OpenStackAuthBackend) vendordataDeadline(ctx context.Context, req *logical.Request, client *Client, instance *Instance) (time.Time, error) {
+	roleNames, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	attestor := NewAttestor(req.Storage, client)
+
+	var deadline time.Time
+	for _, roleName := range roleNames {
+		role, err := readRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if role == nil {
+			continue
+		}
+
+		if role.RoleTagPrefix != "" && instance.Tags == nil {
+			instance.Tags, err = getInstanceTags(client.Compute, instance.ID)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+
+		if attestor.AttestRoleName(instance, role) != nil {
+			continue
+		}
+
+		start, err := attestor.GetAuthPeriodStart(instance, role.AuthPeriodStart)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		d := start.Add(role.AuthPeriod)
+		if d.After(deadline) {
+			deadline = d
+		}
+	}
+
+	if deadline.IsZero() {
+		return time.Time{}, errors.New("role of the instance not found")
+	}
+
+	if time.Now().After(deadline) {
+		return time.Time{}, errors.New("authentication deadline exceeded")
+	}
+
+	return deadline, nil
+}
+
+// verifyVendordataToken validates the Keystone token supplied by Nova
+// and verifies that the token belongs to one of the allowed users.
+func verifyVendordataToken(client *Client, headers []string, userIDs []string) error {
+	if len(headers) == 0 || headers[0] == "" {
+		return errors.New("X-Auth-Token header not found")
+	}
+
+	if client.Identity == nil {
+		return errors.New("identity client is not available")
+	}
+
+	user, err := tokens.Get(client.Identity, headers[0]).ExtractUser()
+	if err != nil {
+		return err
+	}
+
+	if !strutil.StrListContains(userIDs, user.ID) {
+		return fmt.Errorf("user '%s' is not allowed", user.ID)
+	}
+
+	return nil
+}
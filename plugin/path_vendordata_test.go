@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVendordataHandler(t *testing.T) {
+	var tests = []struct {
+		token     string
+		projectID string
+		result    bool
+	}{
+		{"nova", "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d", true},
+		{"nova", "", true},
+		{"nova", "invalid", false},
+		{"other", "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d", false},
+		{"invalid", "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d", false},
+		{"", "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d", false},
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Subject-Token") {
+		case "nova":
+			writeTestJSON(w, `{"token": {"user": {"id": "nova"}}}`)
+		case "other":
+			writeTestJSON(w, `{"token": {"user": {"id": "other"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, fmt.Sprintf(`{"server": {
+			"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+			"tenant_id": "3d7b2f6c4c4a4d4b9d0c0e6f3a1b2c3d",
+			"status": "ACTIVE",
+			"metadata": {"vault-role": "test"},
+			"created": "%s",
+			"updated": "%s"
+		}}`, created, created))
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	b, storage := newTestBackend(t)
+	b.(*OpenStackAuthBackend).client = client
+
+	config := &Config{
+		VendordataUserIDs: []string{"nova"},
+	}
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	role := &Role{Name: "test", MetadataKey: "vault-role", AuthPeriod: 120 * time.Second}
+	entry, err = logical.StorageEntryJSON("role/test", role)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	issued := ""
+	for _, test := range tests {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "vendordata",
+			Storage:   storage,
+			Headers: map[string][]string{
+				"X-Auth-Token": {test.token},
+			},
+			Data: map[string]interface{}{
+				"instance-id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+				"project-id":  test.projectID,
+			},
+		}
+
+		res, err := b.HandleRequest(ctx, req)
+		ok := err == nil && res != nil && !res.IsError()
+		if ok != test.result {
+			t.Errorf("unexpected result: %v - %v, %v", test, res, err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		secret, err := readSecret(ctx, storage, SecretKindVendordata, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+		if err != nil || secret == nil {
+			t.Fatalf("unexpected result: %v - %v", secret, err)
+		}
+
+		if !secret.Verify(res.Data["secret"].(string)) {
+			t.Errorf("unexpected secret: %v", res.Data["secret"])
+		}
+
+		// The same secret must be returned until it is used.
+		if issued != "" && issued != res.Data["secret"].(string) {
+			t.Errorf("secret changed: %v - %v", issued, res.Data["secret"])
+		}
+		issued = res.Data["secret"].(string)
+	}
+
+	cached, err := readSecretCache(ctx, storage, SecretKindVendordata, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil || cached != issued {
+		t.Errorf("unexpected cache: %s - %v", cached, err)
+	}
+
+	attestor := NewAttestor(storage, client)
+	err = attestor.VerifySecrets(newTestInstance(), map[string]string{SecretKindVendordata: issued})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The cache is deleted once the secret has been used.
+	cached, err = readSecretCache(ctx, storage, SecretKindVendordata, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil || cached != "" {
+		t.Errorf("unexpected cache after use: %s - %v", cached, err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "vendordata",
+		Storage:   storage,
+		Headers: map[string][]string{
+			"X-Auth-Token": {"nova"},
+		},
+		Data: map[string]interface{}{
+			"instance-id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5",
+		},
+	}
+
+	res, err := b.HandleRequest(ctx, req)
+	if err != nil || res == nil || !res.IsError() {
+		t.Errorf("expected error with used secret: %v, %v", res, err)
+	}
+}
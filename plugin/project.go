@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+)
+
+const (
+	// projectCacheTTL is the duration to cache the Keystone projects.
+	projectCacheTTL = 5 * time.Minute
+
+	// maxProjectDepth is the maximum depth of the project hierarchy to
+	// look up the parent projects.
+	maxProjectDepth = 10
+)
+
+// Project is a Keystone project.
+type Project struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	DomainID string   `json:"domain_id"`
+	ParentID string   `json:"parent_id"`
+	IsDomain bool     `json:"is_domain"`
+	Tags     []string `json:"tags"`
+}
+
+type projectCacheEntry struct {
+	project    *Project
+	expiration time.Time
+}
+
+// ProjectCache caches the Keystone projects for a while.
+type ProjectCache struct {
+	client  *gophercloud.ServiceClient
+	entries map[string]*projectCacheEntry
+	mutex   sync.Mutex
+}
+
+// NewProjectCache returns new project cache. The client can be nil if the
+// identity service is not available, in which case Get always fails.
+func NewProjectCache(client *gophercloud.ServiceClient) *ProjectCache {
+	return &ProjectCache{
+		client:  client,
+		entries: map[string]*projectCacheEntry{},
+	}
+}
+
+// Get returns the Keystone project. The project is fetched from the
+// identity service if it is not cached or the cache has been expired.
+func (c *ProjectCache) Get(id string) (*Project, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[id]
+	c.mutex.Unlock()
+
+	if ok && time.Now().Before(entry.expiration) {
+		return entry.project, nil
+	}
+
+	if c.client == nil {
+		return nil, errors.New("identity client is not available")
+	}
+
+	var res struct {
+		Project *Project `json:"project"`
+	}
+
+	err := projects.Get(c.client, id).ExtractInto(&res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Project == nil {
+		return nil, errors.New("project not found")
+	}
+
+	c.mutex.Lock()
+	c.entries[id] = &projectCacheEntry{
+		project:    res.Project,
+		expiration: time.Now().Add(projectCacheTTL),
+	}
+	c.mutex.Unlock()
+
+	return res.Project, nil
+}
+
+// GetParents returns the IDs of the parent projects of the Keystone
+// project in order from the nearest. The domain of the top level project
+// is not included.
+func (c *ProjectCache) GetParents(project *Project) ([]string, error) {
+	parents := []string{}
+
+	current := project
+	for i := 0; i < maxProjectDepth; i++ {
+		if current.ParentID == "" || current.ParentID == current.DomainID {
+			return parents, nil
+		}
+
+		parent, err := c.Get(current.ParentID)
+		if err != nil {
+			return nil, err
+		}
+
+		parents = append(parents, parent.ID)
+		current = parent
+	}
+
+	return nil, errors.New("project hierarchy is too deep")
+}
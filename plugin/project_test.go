@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestProjectCache(t *testing.T) {
+	count := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity/v3/projects/child", func(w http.ResponseWriter, r *http.Request) {
+		count++
+		writeTestJSON(w, `{"project": {"id": "child", "domain_id": "default", "parent_id": "parent"}}`)
+	})
+	mux.HandleFunc("/identity/v3/projects/parent", func(w http.ResponseWriter, r *http.Request) {
+		count++
+		writeTestJSON(w, `{"project": {"id": "parent", "domain_id": "default", "parent_id": "default"}}`)
+	})
+	mux.HandleFunc("/identity/v3/projects/loop", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, `{"project": {"id": "loop", "domain_id": "default", "parent_id": "loop"}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	cache := NewProjectCache(client.Identity)
+
+	for i := 0; i < 2; i++ {
+		project, err := cache.Get("child")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		parents, err := cache.GetParents(project)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(parents, []string{"parent"}) {
+			t.Errorf("unexpected parents: %v", parents)
+		}
+	}
+
+	if count != 2 {
+		t.Errorf("unexpected number of requests: %d", count)
+	}
+
+	project, err := cache.Get("loop")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = cache.GetParents(project)
+	if err == nil {
+		t.Errorf("expected error for project hierarchy loop")
+	}
+}
+
+func TestProjectCacheWithoutClient(t *testing.T) {
+	cache := NewProjectCache(nil)
+
+	_, err := cache.Get("child")
+	if err == nil {
+		t.Errorf("expected error without identity client")
+	}
+
+	_, storage := newTestBackend(t)
+	attestor := NewAttestor(storage, &Client{Projects: cache})
+	instance := newTestInstance()
+
+	err = attestor.AttestProject(instance, []string{}, []string{}, []string{})
+	if err != nil {
+		t.Errorf("unexpected error without bindings: %v", err)
+	}
+
+	err = attestor.AttestProject(instance, []string{}, []string{}, []string{"default"})
+	if err == nil {
+		t.Errorf("expected error without identity client")
+	}
+}
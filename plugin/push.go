@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pushSecrets writes the single-use secrets into the metadata of the new
+// instances that carry the role name of the roles enabling push mode. Only
+// the hash of the secret is stored. It returns the number of the secrets
+// pushed.
+func (b *OpenStackAuthBackend) pushSecrets(ctx context.Context, s logical.Storage) (int, error) {
+	roles, err := listPushRoles(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(roles) == 0 {
+		return 0, nil
+	}
+
+	config, err := readConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if config == nil {
+		return 0, nil
+	}
+
+	client, err := b.getClient(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the instances changed in the longest authentication period can
+	// authenticate, so the older instances are not listed.
+	var period time.Duration
+	for _, role := range roles {
+		if role.AuthPeriod > period {
+			period = role.AuthPeriod
+		}
+	}
+
+	// The instances of all projects are listed, which requires the
+	// administrator privilege.
+	opts := servers.ListOpts{
+		AllTenants:   true,
+		ChangesSince: time.Now().Add(-period).UTC().Format(time.RFC3339),
+	}
+
+	pages, err := servers.List(client.Compute, opts).AllPages()
+	if err != nil {
+		return 0, err
+	}
+
+	list, err := servers.ExtractServers(pages)
+	if err != nil {
+		return 0, err
+	}
+
+	attestor := NewAttestor(s, client)
+
+	count := 0
+	for _, srv := range list {
+		role := findPushRole(roles, srv.Metadata)
+		if role == nil {
+			continue
+		}
+
+		secret, err := readSecret(ctx, s, SecretKindPush, srv.ID)
+		if err != nil {
+			return count, err
+		}
+
+		if !pushable(secret) {
+			continue
+		}
+
+		// The extended attributes are not available in the list, so the
+		// instance is fetched again.
+		instance, err := getInstance(client.Compute, srv.ID)
+		if err != nil {
+			b.Logger().Warn("failed to find instance", "instance_id", srv.ID, "error", err)
+			continue
+		}
+
+		start, err := attestor.GetAuthPeriodStart(instance, role.AuthPeriodStart)
+		if err != nil {
+			b.Logger().Warn("failed to get auth period start", "instance_id", instance.ID, "error", err)
+			continue
+		}
+
+		deadline := start.Add(role.AuthPeriod)
+		if time.Now().After(deadline) {
+			continue
+		}
+
+		pushed, err := b.pushSecret(ctx, s, client, instance, role, deadline)
+		if err != nil {
+			b.Logger().Warn("failed to push secret", "instance_id", instance.ID, "error", err)
+			continue
+		}
+
+		if pushed {
+			count += 1
+		}
+	}
+
+	return count, nil
+}
+
+// pushSecret generates a secret and writes it into the metadata of the
+// instance. The secret is not pushed again if it has already been pushed. It
+// returns true if the secret is pushed.
+func (b *OpenStackAuthBackend) pushSecret(ctx context.Context, s logical.Storage, client *Client, instance *Instance, role *Role, deadline time.Time) (bool, error) {
+	value, err := generateSecret()
+	if err != nil {
+		return false, err
+	}
+
+	hash := hashSecret(value)
+
+	// The secret is stored as pending before the metadata is updated, so
+	// that the secret lock is not held during the request to Nova. The
+	// pending secret is overwritten by the next push if the request fails.
+	secretLock.Lock()
+	secret, err := readSecret(ctx, s, SecretKindPush, instance.ID)
+	if err == nil && pushable(secret) {
+		err = updateSecret(ctx, s, SecretKindPush, &Secret{
+			Name:     instance.ID,
+			Hash:     hash,
+			Deadline: deadline,
+			Pending:  true,
+		})
+	}
+	secretLock.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !pushable(secret) {
+		return false, nil
+	}
+
+	opts := servers.MetadataOpts{
+		role.pushSecretMetadataKey(): value,
+	}
+
+	_, err = servers.UpdateMetadata(client.Compute, instance.ID, opts).Extract()
+	if err != nil {
+		return false, err
+	}
+
+	secretLock.Lock()
+	defer secretLock.Unlock()
+
+	secret, err = readSecret(ctx, s, SecretKindPush, instance.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// The secret may have been used or replaced while the metadata was
+	// updated.
+	if secret != nil && secret.Pending && secret.Hash == hash {
+		secret.Pending = false
+		err = updateSecret(ctx, s, SecretKindPush, secret)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	b.Logger().Info("secret pushed", "instance_id", instance.ID, "role", role.Name)
+
+	return true, nil
+}
+
+// pushable returns true if the secret is not stored yet, or if the push of
+// the secret has not completed and the secret is not used.
+func pushable(secret *Secret) bool {
+	return secret == nil || (secret.Pending && !secret.Used)
+}
+
+// listPushRoles returns the roles that enable push mode.
+func listPushRoles(ctx context.Context, s logical.Storage) ([]*Role, error) {
+	names, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []*Role{}
+	for _, name := range names {
+		role, err := readRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role == nil || !role.PushSecret || role.MetadataKey == "" {
+			continue
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// findPushRole returns the first role whose name is contained in the
+// metadata of the instance.
+func findPushRole(roles []*Role, metadata map[string]string) *Role {
+	for _, role := range roles {
+		val, ok := metadata[role.MetadataKey]
+		if !ok {
+			continue
+		}
+
+		roleNames, err := parseMetadataRoles(val, role.MetadataFormat)
+		if err != nil {
+			continue
+		}
+
+		if strutil.StrListContains(roleNames, role.Name) {
+			return role
+		}
+	}
+
+	return nil
+}
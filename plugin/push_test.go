@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPushSecrets(t *testing.T) {
+	created := time.Now().UTC().Format(time.RFC3339)
+	pushed := map[string]string{}
+	failed := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("changes-since") == "" {
+			t.Errorf("changes-since not specified")
+		}
+		if r.URL.Query().Get("all_tenants") != "true" {
+			t.Errorf("all_tenants not specified")
+		}
+		writeTestJSON(w, fmt.Sprintf(`{"servers": [
+			{"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "metadata": {"vault-role": "test"}, "created": "%s", "updated": "%s"},
+			{"id": "2ce4d8fb-2c5c-4b8e-a3b3-7c0e7a2d2f4b", "metadata": {"vault-role": "other"}, "created": "%s", "updated": "%s"},
+			{"id": "8d1b5f6a-9c3e-4f2a-b7d0-1e2f3a4b5c6d", "metadata": {}, "created": "%s", "updated": "%s"}
+		]}`, created, created, created, created, created, created))
+	})
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, fmt.Sprintf(`{"server": {"id": "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5", "metadata": {"vault-role": "test"}, "created": "%s", "updated": "%s"}}`, created, created))
+	})
+	mux.HandleFunc("/compute/servers/ef079b0c-e610-4dfb-b1aa-b49f07ac48e5/metadata", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		pushed = body.Metadata
+		writeTestJSON(w, `{"metadata": {}}`)
+	})
+
+	client, server := newTestClient(t, mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	b, storage := newTestBackend(t)
+	b.(*OpenStackAuthBackend).client = client
+
+	entry, err := logical.StorageEntryJSON("config", &Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	roles := []*Role{
+		{Name: "test", MetadataKey: "vault-role", AuthPeriod: 120 * time.Second, PushSecret: true},
+		{Name: "other", MetadataKey: "vault-role", AuthPeriod: 120 * time.Second},
+	}
+	for _, role := range roles {
+		entry, err := logical.StorageEntryJSON(fmt.Sprintf("role/%s", role.Name), role)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = storage.Put(ctx, entry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The failed push is left pending and retried by the next push.
+	failed = true
+	count, err := b.(*OpenStackAuthBackend).pushSecrets(ctx, storage)
+	if count != 0 || err != nil {
+		t.Fatalf("unexpected result: [%d] %v", count, err)
+	}
+
+	secret, err := readSecret(ctx, storage, SecretKindPush, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil || secret == nil || !secret.Pending {
+		t.Fatalf("unexpected result: %v - %v", secret, err)
+	}
+
+	failed = false
+	count, err = b.(*OpenStackAuthBackend).pushSecrets(ctx, storage)
+	if count != 1 || err != nil {
+		t.Fatalf("unexpected result: [%d] %v", count, err)
+	}
+
+	secret, err = readSecret(ctx, storage, SecretKindPush, "ef079b0c-e610-4dfb-b1aa-b49f07ac48e5")
+	if err != nil || secret == nil || secret.Pending {
+		t.Fatalf("unexpected result: %v - %v", secret, err)
+	}
+
+	if !secret.Verify(pushed["vault-secret"]) {
+		t.Errorf("unexpected pushed metadata: %v", pushed)
+	}
+
+	count, err = b.(*OpenStackAuthBackend).pushSecrets(ctx, storage)
+	if count != 0 || err != nil {
+		t.Errorf("unexpected result: [%d] %v", count, err)
+	}
+}
@@ -4,39 +4,242 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+const (
+	// AddressSourceNova attests the source address with the addresses
+	// reported by Nova.
+	AddressSourceNova = "nova"
+	// AddressSourceNeutron attests the source address with the Neutron
+	// ports attached to the instance.
+	AddressSourceNeutron = "neutron"
+)
+
+const (
+	// TagsModeAll requires all of the bound server tags.
+	TagsModeAll = "all"
+	// TagsModeAny requires any of the bound server tags.
+	TagsModeAny = "any"
+)
+
+const (
+	// MetadataFormatString reads the metadata value as a single role name.
+	MetadataFormatString = "string"
+	// MetadataFormatCSV reads the metadata value as comma separated role
+	// names.
+	MetadataFormatCSV = "csv"
+	// MetadataFormatJSON reads the metadata value as a JSON array of role
+	// names.
+	MetadataFormatJSON = "json"
+)
+
+const (
+	// AuthPeriodStartCreated starts the authentication period at the
+	// creation time of the instance.
+	AuthPeriodStartCreated = "created"
+	// AuthPeriodStartLaunched starts the authentication period at the
+	// launch time of the instance.
+	AuthPeriodStartLaunched = "launched"
+	// AuthPeriodStartRebuilt starts the authentication period at the
+	// latest rebuild of the instance.
+	AuthPeriodStartRebuilt = "rebuilt"
+)
+
+// defaultAllowedStatuses is the instance statuses that are allowed to
+// authenticate if allowed_statuses is not specified.
+var defaultAllowedStatuses = []string{"ACTIVE"}
+
+// defaultPushSecretMetadataKey is the metadata key that the secret is
+// pushed into if push_secret_metadata_key is not specified.
+const defaultPushSecretMetadataKey = "vault-secret"
+
+// defaultDeniedInstanceActions is the instance actions that are denied
+// after the cutoff if denied_instance_actions is not specified.
+var defaultDeniedInstanceActions = []string{"rebuild", "evacuate", "changePassword"}
+
+const (
+	// PatternTypeGlob matches the patterns as glob patterns.
+	PatternTypeGlob = "glob"
+	// PatternTypeRegex matches the patterns as regular expressions.
+	PatternTypeRegex = "regex"
+)
+
+const (
+	// ProxyModeNone uses the address of the connection as the source
+	// address.
+	ProxyModeNone = "none"
+	// ProxyModeForwarded takes the source address from X-Forwarded-For
+	// header if the connection comes from a trusted proxy.
+	ProxyModeForwarded = "forwarded"
+	// ProxyModeSkip skips the source address validation if the connection
+	// comes from a trusted proxy.
+	ProxyModeSkip = "skip"
+)
+
 type Role struct {
-	Name        string        `json:"name" structs:"name" mapstructure:"name"`
-	Policies    []string      `json:"policies" structs:"policies" mapstructure:"policies"`
-	TTL         time.Duration `json:"ttl" structs:"ttl" mapstructure:"ttl"`
-	MaxTTL      time.Duration `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
-	Period      time.Duration `json:"period" structs:"period" mapstructure:"period"`
-	MetadataKey string        `json:"metadata_key" structs:"metadata_key" mapstructure:"metadata_key"`
-	TenantID    string        `json:"tenant_id" structs:"tenant_id" mapstructure:"tenant_id"`
-	UserID      string        `json:"user_id" structs:"user_id" mapstructure:"user_id"`
-	AuthPeriod  time.Duration `json:"auth_period" structs:"auth_period" mapstructure:"auth_period"`
-	AuthLimit   int           `json:"auth_limit" structs:"auth_limit" mapstructure:"auth_limit"`
+	Name                      string              `json:"name" structs:"name" mapstructure:"name"`
+	Policies                  []string            `json:"policies" structs:"policies" mapstructure:"policies"`
+	TTL                       time.Duration       `json:"ttl" structs:"ttl" mapstructure:"ttl"`
+	MaxTTL                    time.Duration       `json:"max_ttl" structs:"max_ttl" mapstructure:"max_ttl"`
+	Period                    time.Duration       `json:"period" structs:"period" mapstructure:"period"`
+	MetadataKey               string              `json:"metadata_key" structs:"metadata_key" mapstructure:"metadata_key"`
+	MetadataFormat            string              `json:"metadata_format" structs:"metadata_format" mapstructure:"metadata_format"`
+	RoleTagPrefix             string              `json:"role_tag_prefix" structs:"role_tag_prefix" mapstructure:"role_tag_prefix"`
+	BoundInstanceNamePatterns []string            `json:"bound_instance_name_patterns" structs:"bound_instance_name_patterns" mapstructure:"bound_instance_name_patterns"`
+	InstanceNamePatternType   string              `json:"instance_name_pattern_type" structs:"instance_name_pattern_type" mapstructure:"instance_name_pattern_type"`
+	BoundMetadata             map[string][]string `json:"bound_metadata" structs:"bound_metadata" mapstructure:"bound_metadata"`
+	BoundProjectIDs           []string            `json:"bound_project_ids" structs:"bound_project_ids" mapstructure:"bound_project_ids"`
+	BoundUserIDs              []string            `json:"bound_user_ids" structs:"bound_user_ids" mapstructure:"bound_user_ids"`
+	BoundParentProjectIDs     []string            `json:"bound_parent_project_ids" structs:"bound_parent_project_ids" mapstructure:"bound_parent_project_ids"`
+	BoundProjectTags          []string            `json:"bound_project_tags" structs:"bound_project_tags" mapstructure:"bound_project_tags"`
+	BoundDomainIDs            []string            `json:"bound_domain_ids" structs:"bound_domain_ids" mapstructure:"bound_domain_ids"`
+	BoundImageIDs             []string            `json:"bound_image_ids" structs:"bound_image_ids" mapstructure:"bound_image_ids"`
+	BoundImageProperties      map[string]string   `json:"bound_image_properties" structs:"bound_image_properties" mapstructure:"bound_image_properties"`
+	BoundFlavorIDs            []string            `json:"bound_flavor_ids" structs:"bound_flavor_ids" mapstructure:"bound_flavor_ids"`
+	BoundFlavorNames          []string            `json:"bound_flavor_names" structs:"bound_flavor_names" mapstructure:"bound_flavor_names"`
+	BoundAvailabilityZones    []string            `json:"bound_availability_zones" structs:"bound_availability_zones" mapstructure:"bound_availability_zones"`
+	BoundHostAggregates       []string            `json:"bound_host_aggregates" structs:"bound_host_aggregates" mapstructure:"bound_host_aggregates"`
+	BoundKeyNames             []string            `json:"bound_key_names" structs:"bound_key_names" mapstructure:"bound_key_names"`
+	BoundKeyFingerprints      []string            `json:"bound_key_fingerprints" structs:"bound_key_fingerprints" mapstructure:"bound_key_fingerprints"`
+	BoundServerGroupIDs       []string            `json:"bound_server_group_ids" structs:"bound_server_group_ids" mapstructure:"bound_server_group_ids"`
+	BoundServerTags           []string            `json:"bound_server_tags" structs:"bound_server_tags" mapstructure:"bound_server_tags"`
+	BoundServerTagsMode       string              `json:"bound_server_tags_mode" structs:"bound_server_tags_mode" mapstructure:"bound_server_tags_mode"`
+	BoundSecurityGroups       []string            `json:"bound_security_groups" structs:"bound_security_groups" mapstructure:"bound_security_groups"`
+	VerifyPortSecurityGroups  bool                `json:"verify_port_security_groups" structs:"verify_port_security_groups" mapstructure:"verify_port_security_groups"`
+	BoundNetworkIDs           []string            `json:"bound_network_ids" structs:"bound_network_ids" mapstructure:"bound_network_ids"`
+	BoundSubnetCIDRs          []string            `json:"bound_subnet_cidrs" structs:"bound_subnet_cidrs" mapstructure:"bound_subnet_cidrs"`
+	AllowedStatuses           []string            `json:"allowed_statuses" structs:"allowed_statuses" mapstructure:"allowed_statuses"`
+	AuthPeriod                time.Duration       `json:"auth_period" structs:"auth_period" mapstructure:"auth_period"`
+	AuthPeriodStart           string              `json:"auth_period_start" structs:"auth_period_start" mapstructure:"auth_period_start"`
+	MinInstanceAge            time.Duration       `json:"min_instance_age" structs:"min_instance_age" mapstructure:"min_instance_age"`
+	MaxInstanceAge            time.Duration       `json:"max_instance_age" structs:"max_instance_age" mapstructure:"max_instance_age"`
+	DeniedInstanceActions     []string            `json:"denied_instance_actions" structs:"denied_instance_actions" mapstructure:"denied_instance_actions"`
+	InstanceActionCutoff      string              `json:"instance_action_cutoff" structs:"instance_action_cutoff" mapstructure:"instance_action_cutoff"`
+	AuthLimit                 int                 `json:"auth_limit" structs:"auth_limit" mapstructure:"auth_limit"`
+	AddressSource             string              `json:"address_source" structs:"address_source" mapstructure:"address_source"`
+	ProxyMode                 string              `json:"proxy_mode" structs:"proxy_mode" mapstructure:"proxy_mode"`
+	RequireVendordataSecret   bool                `json:"require_vendordata_secret" structs:"require_vendordata_secret" mapstructure:"require_vendordata_secret"`
+	RequireChallenge          bool                `json:"require_challenge" structs:"require_challenge" mapstructure:"require_challenge"`
+	PushSecret                bool                `json:"push_secret" structs:"push_secret" mapstructure:"push_secret"`
+	PushSecretMetadataKey     string              `json:"push_secret_metadata_key" structs:"push_secret_metadata_key" mapstructure:"push_secret_metadata_key"`
+	UserDataMarker            string              `json:"user_data_marker" structs:"user_data_marker" mapstructure:"user_data_marker"`
+	RequireIdentityDocument   bool                `json:"require_identity_document" structs:"require_identity_document" mapstructure:"require_identity_document"`
+
+	// Deprecated: TenantID and UserID are only used to read the role stored
+	// by older versions. They are migrated to BoundProjectIDs and BoundUserIDs.
+	TenantID string `json:"tenant_id,omitempty" structs:"-" mapstructure:"-"`
+	UserID   string `json:"user_id,omitempty" structs:"-" mapstructure:"-"`
 }
 
 func (r *Role) Validate(sys logical.SystemView) (warnings []string, err error) {
 	warnings = []string{}
 
-	if r.MetadataKey == "" {
-		return warnings, errors.New("metadata_key cannot be empty")
+	if r.MetadataKey == "" && r.RoleTagPrefix == "" {
+		return warnings, errors.New("metadata_key or role_tag_prefix must be specified")
 	}
 
 	if r.AuthPeriod < time.Duration(0) {
 		return warnings, errors.New("auth_period cannot be negative")
 	}
 
+	if r.PushSecret {
+		if r.MetadataKey == "" {
+			return warnings, errors.New("metadata_key must be specified to push the secret")
+		}
+
+		if r.pushSecretMetadataKey() == r.MetadataKey {
+			return warnings, errors.New("push_secret_metadata_key must be different from metadata_key")
+		}
+	}
+
+	switch r.MetadataFormat {
+	case "", MetadataFormatString, MetadataFormatCSV, MetadataFormatJSON:
+	default:
+		return warnings, fmt.Errorf("invalid metadata_format: %s", r.MetadataFormat)
+	}
+
+	switch r.AuthPeriodStart {
+	case "", AuthPeriodStartCreated, AuthPeriodStartLaunched, AuthPeriodStartRebuilt:
+	default:
+		return warnings, fmt.Errorf("invalid auth_period_start: %s", r.AuthPeriodStart)
+	}
+
+	if r.MinInstanceAge < time.Duration(0) {
+		return warnings, errors.New("min_instance_age cannot be negative")
+	}
+
+	if r.MaxInstanceAge < time.Duration(0) {
+		return warnings, errors.New("max_instance_age cannot be negative")
+	}
+
+	if r.MinInstanceAge > 0 && r.MinInstanceAge >= r.AuthPeriod {
+		return warnings, errors.New("min_instance_age must be less than auth_period")
+	}
+
+	if r.InstanceActionCutoff != "" {
+		_, err := time.Parse(time.RFC3339, r.InstanceActionCutoff)
+		if err != nil {
+			return warnings, fmt.Errorf("invalid instance_action_cutoff: %v", err)
+		}
+	}
+
 	if r.AuthLimit < 0 {
 		return warnings, errors.New("auth_limit cannot be negative")
 	}
 
+	switch r.AddressSource {
+	case "", AddressSourceNova, AddressSourceNeutron:
+	default:
+		return warnings, fmt.Errorf("invalid address_source: %s", r.AddressSource)
+	}
+
+	switch r.InstanceNamePatternType {
+	case "", PatternTypeGlob:
+	case PatternTypeRegex:
+		for _, pattern := range r.BoundInstanceNamePatterns {
+			_, err := regexp.Compile(pattern)
+			if err != nil {
+				return warnings, fmt.Errorf("invalid bound_instance_name_patterns: %v", err)
+			}
+		}
+	default:
+		return warnings, fmt.Errorf("invalid instance_name_pattern_type: %s", r.InstanceNamePatternType)
+	}
+
+	for _, cidr := range r.BoundSubnetCIDRs {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return warnings, fmt.Errorf("invalid bound_subnet_cidrs: %v", err)
+		}
+	}
+
+	switch r.BoundServerTagsMode {
+	case "", TagsModeAll, TagsModeAny:
+	default:
+		return warnings, fmt.Errorf("invalid bound_server_tags_mode: %s", r.BoundServerTagsMode)
+	}
+
+	switch r.ProxyMode {
+	case "", ProxyModeNone, ProxyModeForwarded, ProxyModeSkip:
+	default:
+		return warnings, fmt.Errorf("invalid proxy_mode: %s", r.ProxyMode)
+	}
+
+	if r.ProxyMode == ProxyModeSkip && r.usesNetworkBindings() {
+		return warnings, errors.New("bound_network_ids and bound_subnet_cidrs cannot be used with proxy_mode 'skip'")
+	}
+
+	if len(r.BoundSecurityGroups) > 0 && len(r.BoundProjectIDs) == 0 {
+		warnings = append(warnings,
+			"bound_security_groups are matched only by the ID with verify_port_security_groups since bound_project_ids is not set")
+	}
+
 	defaultLeaseTTL := sys.DefaultLeaseTTL()
 	if r.TTL > defaultLeaseTTL {
 		warnings = append(warnings, fmt.Sprintf(
@@ -66,6 +269,52 @@ func (r *Role) Validate(sys logical.SystemView) (warnings []string, err error) {
 	return warnings, nil
 }
 
+// usesServerTags returns true if the role requires the server tags of
+// the instance.
+func (r *Role) usesServerTags() bool {
+	return r.RoleTagPrefix != "" || len(r.BoundServerTags) > 0
+}
+
+// usesNetworkBindings returns true if the role is bound to the networks or
+// the subnets of the source address.
+func (r *Role) usesNetworkBindings() bool {
+	return len(r.BoundNetworkIDs) > 0 || len(r.BoundSubnetCIDRs) > 0
+}
+
+// pushSecretMetadataKey returns the metadata key that the secret is pushed
+// into.
+func (r *Role) pushSecretMetadataKey() string {
+	if r.PushSecretMetadataKey == "" {
+		return defaultPushSecretMetadataKey
+	}
+
+	return r.PushSecretMetadataKey
+}
+
+// upgrade migrates the deprecated fields of the role. It returns true if
+// the role has been changed.
+func (r *Role) upgrade() bool {
+	upgraded := false
+
+	if r.TenantID != "" {
+		if !strutil.StrListContains(r.BoundProjectIDs, r.TenantID) {
+			r.BoundProjectIDs = append(r.BoundProjectIDs, r.TenantID)
+		}
+		r.TenantID = ""
+		upgraded = true
+	}
+
+	if r.UserID != "" {
+		if !strutil.StrListContains(r.BoundUserIDs, r.UserID) {
+			r.BoundUserIDs = append(r.BoundUserIDs, r.UserID)
+		}
+		r.UserID = ""
+		upgraded = true
+	}
+
+	return upgraded
+}
+
 func readRole(ctx context.Context, s logical.Storage, name string) (*Role, error) {
 	entry, err := s.Get(ctx, fmt.Sprintf("role/%s", name))
 	if err != nil {
@@ -82,5 +331,17 @@ func readRole(ctx context.Context, s logical.Storage, name string) (*Role, error
 		return nil, err
 	}
 
+	if role.upgrade() {
+		entry, err := logical.StorageEntryJSON(fmt.Sprintf("role/%s", name), role)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.Put(ctx, entry)
+		if err != nil && err != logical.ErrReadOnly {
+			return nil, err
+		}
+	}
+
 	return role, nil
 }
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReadRoleUpgrade(t *testing.T) {
+	ctx := context.Background()
+	_, storage := newTestBackend(t)
+
+	entry := &logical.StorageEntry{
+		Key:   "role/test",
+		Value: []byte(`{"name":"test","metadata_key":"vault-role","tenant_id":"project1","user_id":"user1"}`),
+	}
+	err := storage.Put(ctx, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := readRole(ctx, storage, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(role.BoundProjectIDs, []string{"project1"}) {
+		t.Errorf("unexpected bound_project_ids: %v", role.BoundProjectIDs)
+	}
+	if !reflect.DeepEqual(role.BoundUserIDs, []string{"user1"}) {
+		t.Errorf("unexpected bound_user_ids: %v", role.BoundUserIDs)
+	}
+	if role.TenantID != "" || role.UserID != "" {
+		t.Errorf("deprecated fields remain: %v", role)
+	}
+
+	entry, err = storage.Get(ctx, "role/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[string]interface{}{}
+	err = entry.DecodeJSON(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := stored["tenant_id"]; ok {
+		t.Errorf("tenant_id has not been migrated: %s", string(entry.Value))
+	}
+	if _, ok := stored["user_id"]; ok {
+		t.Errorf("user_id has not been migrated: %s", string(entry.Value))
+	}
+}
+
+func TestRoleValidate(t *testing.T) {
+	var tests = []struct {
+		role   *Role
+		result bool
+	}{
+		{&Role{MetadataKey: "vault-role"}, true},
+		{&Role{RoleTagPrefix: "vault-role="}, true},
+		{&Role{}, false},
+		{&Role{MetadataKey: "vault-role", BoundInstanceNamePatterns: []string{"web-*"}}, true},
+		{&Role{MetadataKey: "vault-role", BoundInstanceNamePatterns: []string{"^web-[0-9]+$"}, InstanceNamePatternType: "regex"}, true},
+		{&Role{MetadataKey: "vault-role", BoundInstanceNamePatterns: []string{"("}, InstanceNamePatternType: "regex"}, false},
+		{&Role{MetadataKey: "vault-role", InstanceNamePatternType: "invalid"}, false},
+		{&Role{MetadataKey: "vault-role", AuthPeriodStart: "rebuilt"}, true},
+		{&Role{MetadataKey: "vault-role", AuthPeriodStart: "invalid"}, false},
+		{&Role{MetadataKey: "vault-role", AuthPeriod: 120 * time.Second, MinInstanceAge: 30 * time.Second}, true},
+		{&Role{MetadataKey: "vault-role", AuthPeriod: 120 * time.Second, MinInstanceAge: 120 * time.Second}, false},
+		{&Role{MetadataKey: "vault-role", MaxInstanceAge: -1}, false},
+		{&Role{MetadataKey: "vault-role", InstanceActionCutoff: "2020-01-01T00:00:00Z"}, true},
+		{&Role{MetadataKey: "vault-role", InstanceActionCutoff: "2020-01-01"}, false},
+		{&Role{MetadataKey: "vault-role", PushSecret: true}, true},
+		{&Role{MetadataKey: "vault-role", PushSecret: true, PushSecretMetadataKey: "vault-role"}, false},
+		{&Role{RoleTagPrefix: "vault-role=", PushSecret: true}, false},
+		{&Role{MetadataKey: "vault-role", BoundSubnetCIDRs: []string{"192.168.1.0/24"}}, true},
+		{&Role{MetadataKey: "vault-role", BoundSubnetCIDRs: []string{"invalid"}}, false},
+		{&Role{MetadataKey: "vault-role", BoundNetworkIDs: []string{"trusted"}, ProxyMode: "forwarded"}, true},
+		{&Role{MetadataKey: "vault-role", BoundNetworkIDs: []string{"trusted"}, ProxyMode: "skip"}, false},
+		{&Role{MetadataKey: "vault-role", BoundSubnetCIDRs: []string{"192.168.1.0/24"}, ProxyMode: "skip"}, false},
+	}
+
+	b, _ := newTestBackend(t)
+	sys := b.(*OpenStackAuthBackend).System()
+
+	for _, test := range tests {
+		_, err := test.role.Validate(sys)
+		if (err == nil) != test.result {
+			t.Errorf("unexpected result: %v - %v", test.role, err)
+		}
+	}
+}
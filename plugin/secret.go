@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// SecretKindVendordata is the kind of the secret issued by the
+	// vendordata endpoint.
+	SecretKindVendordata = "vendordata"
+	// SecretKindChallenge is the kind of the nonce issued by the challenge
+	// endpoint.
+	SecretKindChallenge = "challenge"
+	// SecretKindPush is the kind of the secret pushed into the metadata of
+	// the instance.
+	SecretKindPush = "push"
+)
+
+// secretKinds is the list of the kinds of the secrets in the order of the
+// verification.
+var secretKinds = []string{SecretKindVendordata, SecretKindPush, SecretKindChallenge}
+
+// secretLock serializes the verification and the issuance of the secrets
+// so that a secret cannot be used twice by concurrent requests.
+var secretLock sync.Mutex
+
+// Secret is a single-use secret bound to the instance. Only the hash of
+// the secret is stored.
+type Secret struct {
+	Name     string    `json:"name" structs:"name" mapstructure:"name"`
+	Hash     string    `json:"hash" structs:"hash" mapstructure:"hash"`
+	Deadline time.Time `json:"deadline" structs:"deadline" mapstructure:"deadline"`
+	Used     bool      `json:"used" structs:"used" mapstructure:"used"`
+	Pending  bool      `json:"pending" structs:"pending" mapstructure:"pending"`
+}
+
+// Verify returns true if the secret matches the hash.
+func (s *Secret) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.Hash), []byte(hashSecret(secret))) == 1
+}
+
+// generateSecret returns a new random secret.
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// secretName returns the storage name of the secret bound to the instance.
+// The challenges are named by the hash of the instance ID and the nonce so
+// that several challenges of the instance can be outstanding at once. The
+// other secrets are named by the instance ID.
+func secretName(kind string, instanceID string, secret string) string {
+	if kind == SecretKindChallenge {
+		return hashSecret(fmt.Sprintf("%s/%s", instanceID, secret))
+	}
+
+	return instanceID
+}
+
+// hashSecret returns the SHA-256 hash of the secret.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func readSecret(ctx context.Context, s logical.Storage, kind string, name string) (*Secret, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("secret/%s/%s", kind, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	secret := &Secret{}
+	err = entry.DecodeJSON(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func updateSecret(ctx context.Context, s logical.Storage, kind string, secret *Secret) error {
+	if secret.Name == "" {
+		return errors.New("invalid secret name")
+	}
+
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("secret/%s/%s", kind, secret.Name), secret)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func cleanupSecret(ctx context.Context, s logical.Storage, kind string) (int, error) {
+	count := 0
+
+	keys, err := s.List(ctx, fmt.Sprintf("secret/%s/", kind))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		secret, err := readSecret(ctx, s, kind, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if time.Now().After(secret.Deadline) {
+			err := s.Delete(ctx, fmt.Sprintf("secret/%s/%s", kind, key))
+			if err != nil {
+				return 0, err
+			}
+
+			err = deleteSecretCache(ctx, s, kind, key)
+			if err != nil {
+				return 0, err
+			}
+			count += 1
+		}
+	}
+
+	return count, nil
+}
+
+// readSecretCache returns the plain secret cached until it is used. The
+// cache allows the secret to be returned again without storing anything
+// that can derive the secret. The cache is stored under secret_cache/, which
+// is seal wrapped if the seal supports it. Otherwise, the secret can be read
+// by anyone who can read the storage until it is used, as can the other
+// entries such as the configuration.
+func readSecretCache(ctx context.Context, s logical.Storage, kind string, name string) (string, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("secret_cache/%s/%s", kind, name))
+	if err != nil {
+		return "", err
+	}
+
+	if entry == nil {
+		return "", nil
+	}
+
+	return string(entry.Value), nil
+}
+
+func updateSecretCache(ctx context.Context, s logical.Storage, kind string, name string, value string) error {
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   fmt.Sprintf("secret_cache/%s/%s", kind, name),
+		Value: []byte(value),
+	})
+}
+
+func deleteSecretCache(ctx context.Context, s logical.Storage, kind string, name string) error {
+	return s.Delete(ctx, fmt.Sprintf("secret_cache/%s/%s", kind, name))
+}
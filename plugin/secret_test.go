@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSecretVerify(t *testing.T) {
+	value, err := generateSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret := &Secret{Name: "test", Hash: hashSecret(value)}
+
+	if !secret.Verify(value) {
+		t.Errorf("expected secret to be verified")
+	}
+
+	if secret.Verify("invalid") {
+		t.Errorf("expected secret not to be verified")
+	}
+}
+
+func TestCleanupSecret(t *testing.T) {
+	ctx := context.Background()
+	_, storage := newTestBackend(t)
+
+	secrets := []*Secret{
+		{Name: "expired", Hash: hashSecret("expired"), Deadline: time.Now().Add(-time.Minute)},
+		{Name: "valid", Hash: hashSecret("valid"), Deadline: time.Now().Add(time.Minute)},
+	}
+
+	for _, secret := range secrets {
+		err := updateSecret(ctx, storage, SecretKindVendordata, secret)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = updateSecretCache(ctx, storage, SecretKindVendordata, secret.Name, secret.Name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	count, err := cleanupSecret(ctx, storage, SecretKindVendordata)
+	if count != 1 || err != nil {
+		t.Errorf("unexpected result: [%d] %v", count, err)
+	}
+
+	secret, err := readSecret(ctx, storage, SecretKindVendordata, "valid")
+	if secret == nil || err != nil {
+		t.Errorf("unexpected result: %v - %v", secret, err)
+	}
+
+	cached, err := readSecretCache(ctx, storage, SecretKindVendordata, "expired")
+	if cached != "" || err != nil {
+		t.Errorf("unexpected cache: %s - %v", cached, err)
+	}
+
+	cached, err = readSecretCache(ctx, storage, SecretKindVendordata, "valid")
+	if cached != "valid" || err != nil {
+		t.Errorf("unexpected cache: %s - %v", cached, err)
+	}
+}